@@ -0,0 +1,173 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+
+	"github.com/go-yaml/yaml"
+)
+
+// autoannex configuration, read from a YAML file
+type Config struct {
+	// Paths of the git and git-annex executables to run. Empty means look
+	// them up in $PATH.
+	Git      string `yaml:"git"`
+	GitAnnex string `yaml:"git-annex"`
+	// Extra environment variables for git and git-annex
+	Env map[string]string `yaml:"env"`
+	// SSH hosts that are slow to reach, such as those across the internet.
+	// git-annex prefers any other copy over theirs.
+	SlowHosts []string `yaml:"slow-hosts"`
+	// Where and how much to log
+	Log LogConfig `yaml:"log"`
+	// File recording each run. Defaults to
+	// $XDG_STATE_HOME/autoannex/history.jsonl
+	History string `yaml:"history"`
+	// Which filesystems to search for members
+	Discovery DiscoveryConfig `yaml:"discovery"`
+	// Repository groups, keyed by signature UUID
+	Groups map[string]*GroupConfig `yaml:"groups"`
+}
+
+// Logging configuration
+type LogConfig struct {
+	// Minimum level to log: debug, info (the default), warn or error
+	Level string `yaml:"level"`
+	// Central log file. Defaults to $XDG_STATE_HOME/autoannex/autoannex.log
+	File string `yaml:"file"`
+	// Whether to also log to each member's .git/logs/autoannex.log
+	RepoLogs bool `yaml:"repo-logs"`
+	// Size in bytes at which a log is rotated, and how many rotated logs to
+	// keep. Keep is a pointer so that 0, keeping none, differs from unset.
+	MaxSize int64 `yaml:"max-size"`
+	Keep    *int  `yaml:"keep"`
+}
+
+// Discovery configuration
+type DiscoveryConfig struct {
+	// Whether to search network filesystems such as NFS, CIFS and sshfs,
+	// which can hang discovery while their server is unreachable
+	NetworkFilesystems bool `yaml:"network-filesystems"`
+	// How long to wait for each mount point to respond before skipping it,
+	// eg "10s". Defaults to 5s.
+	StatTimeout string `yaml:"stat-timeout"`
+}
+
+// Configuration of a repository group
+type GroupConfig struct {
+	// Labels for selecting groups, as in sync --all --tag
+	Tags []string `yaml:"tags"`
+	// Desired number of copies of each file, if non-zero
+	NumCopies int `yaml:"numcopies"`
+	// Whether to skip dropping content from members left with merge
+	// conflicts or new variant files by a sync
+	ConflictsBlockDrop bool `yaml:"conflicts-block-drop"`
+	// When install-service's timer syncs the group, as a systemd calendar
+	// event such as "daily". Defaults to hourly.
+	Schedule string `yaml:"schedule"`
+	// Space to leave free on each member's filesystem when getting content,
+	// eg "10G". Gets that would use it are limited to the files that fit.
+	DiskReserve string `yaml:"disk-reserve"`
+	// Steps to run on each member when syncing. If empty, the steps are
+	// chosen by the sync command's flags.
+	Steps []StepConfig `yaml:"steps"`
+	// Commands to run on events such as pre-sync, keyed by event
+	Hooks map[string][]string `yaml:"hooks"`
+	// Whether to run hooks found in each member's .autoannex/hooks. Off by
+	// default, as they come from the members, eg a drive that was plugged in.
+	RepoHooks bool `yaml:"repo-hooks"`
+	// Group members, keyed by path (or host:path for SSH members)
+	Members map[string]*MemberConfig `yaml:"members"`
+}
+
+// Configuration of a single group member
+type MemberConfig struct {
+	// Preferred content policy. One of git-annex's standard groups, eg
+	// "client", "backup" or "archive"
+	Policy string `yaml:"policy"`
+	// Custom preferred content expression, overriding the policy's
+	Wanted string `yaml:"wanted"`
+	// Required content expression
+	Required string `yaml:"required"`
+	// Commands to run on events such as pre-sync, keyed by event
+	Hooks map[string][]string `yaml:"hooks"`
+	// Whether to run hooks found in the member's .autoannex/hooks, as
+	// repo-hooks does for the whole group
+	RepoHooks bool `yaml:"repo-hooks"`
+	// On a filesystem without symlinks, such as exFAT, whether to switch the
+	// member to an adjusted unlocked branch instead of just warning
+	Adjust bool `yaml:"adjust"`
+}
+
+// Returns the default configuration file location
+func defaultConfigPath() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		usr, err := user.Current()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(usr.HomeDir, ".config")
+	}
+	return filepath.Join(dir, "autoannex", "config.yaml")
+}
+
+// Reads the configuration file at path. A missing file is not an error, and
+// results in an empty configuration.
+func loadConfig(path string) (c *Config, err error) {
+	c = &Config{}
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		err = nil
+	}
+	if err != nil || len(b) == 0 {
+		return c, err
+	}
+	err = yaml.Unmarshal(b, c)
+	return c, err
+}
+
+// Returns the configuration of group uuid, which is empty if the group is not
+// configured
+func (c *Config) Group(uuid string) *GroupConfig {
+	if g, ok := c.Groups[uuid]; ok && g != nil {
+		return g
+	}
+	return &GroupConfig{}
+}
+
+// Returns the signature UUIDs of the configured groups, sorted
+func (c *Config) GroupNames() (uuids []string) {
+	for i := range c.Groups {
+		uuids = append(uuids, i)
+	}
+	sort.Strings(uuids)
+	return
+}
+
+// Returns true if the group has any of tags, or if no tags are given
+func (g *GroupConfig) HasTag(tags []string) bool {
+	if len(tags) == 0 {
+		return true
+	}
+	for _, i := range tags {
+		for _, j := range g.Tags {
+			if i == j {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Returns the configuration of member path, which is empty if the member is
+// not configured
+func (g *GroupConfig) Member(path string) *MemberConfig {
+	if m, ok := g.Members[path]; ok && m != nil {
+		return m
+	}
+	return &MemberConfig{}
+}
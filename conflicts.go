@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/hypoactiv/autoannex/goannex"
+)
+
+// Returned for a drop step refused because the member has conflicts
+var errConflicts = errors.New("not dropping content while there are unresolved conflicts or new variant files")
+
+// Returns the variant files in r, for comparing with those left after a
+// sync. Errors are logged, and result in no variants.
+func listVariants(r *goannex.Repo) []string {
+	v, err := r.Variants()
+	if err != nil {
+		appLog.Warn(r.Path, "unable to list variant files: %v", err)
+	}
+	return v
+}
+
+// Records in m the variant files created since before, and any unresolved
+// conflicts, after a sync of the member r
+func checkConflicts(r *goannex.Repo, m *memberResult, before []string) {
+	existed := make(map[string]bool)
+	for _, i := range before {
+		existed[i] = true
+	}
+	for _, i := range listVariants(r) {
+		if !existed[i] {
+			m.variants = appendNew(m.variants, i)
+		}
+	}
+	unresolved, err := r.Conflicts()
+	if err != nil {
+		appLog.Warn(r.Path, "unable to list conflicts: %v", err)
+	}
+	for _, i := range unresolved {
+		m.conflicts = appendNew(m.conflicts, i)
+	}
+	if m.hasConflicts() {
+		fmt.Println("Warning:", r.Path, "has", len(m.conflicts), "unresolved conflict(s) and", len(m.variants), "new variant file(s)")
+		appLog.Warn(r.Path, "%d unresolved conflict(s): %v, %d new variant file(s): %v", len(m.conflicts), m.conflicts, len(m.variants), m.variants)
+	}
+}
+
+// Appends s to list unless it's already there
+func appendNew(list []string, s string) []string {
+	for _, i := range list {
+		if i == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	sh "github.com/codeskyblue/go-sh"
+	"github.com/hypoactiv/autoannex/dirsig"
+)
+
+// Watches for filesystems being mounted, and syncs the groups with members
+// on them
+func daemonCmdRun() {
+	command, err := selfCommand()
+	if err != nil {
+		fmt.Println("error:", err)
+		os.Exit(exitError)
+	}
+	w, err := dirsig.WatchMounts()
+	if err != nil {
+		fmt.Println("error: unable to watch mounts:", err)
+		os.Exit(exitError)
+	}
+	defer w.Close()
+	fmt.Println("Watching for mounted filesystems ...")
+	appLog.Info("", "daemon started")
+	debounceMounts(w.Events, *daemonDebounce, func(mounts []string) {
+		syncMounts(command, mounts)
+	})
+	if w.Err != nil {
+		fmt.Println("error: stopped watching mounts:", w.Err)
+		appLog.Error("", "stopped watching mounts: %v", w.Err)
+		os.Exit(exitError)
+	}
+}
+
+// Collects mount events until none have arrived for delay, then calls run
+// with the mount points mounted since the last call and still mounted, so
+// that a drive mounting several partitions results in a single call. run is
+// never called concurrently; events arriving meanwhile wait for the next
+// call. Returns when events is closed.
+func debounceMounts(events <-chan dirsig.MountEvent, delay time.Duration, run func(mounts []string)) {
+	pending := make(map[string]bool)
+	var timer <-chan time.Time
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				return
+			}
+			if e.Mounted {
+				appLog.Debug("", "%s mounted", e.Mountpoint)
+				pending[e.Mountpoint] = true
+			} else {
+				appLog.Debug("", "%s unmounted", e.Mountpoint)
+				delete(pending, e.Mountpoint)
+			}
+			timer = nil
+			if len(pending) > 0 {
+				timer = time.After(delay)
+			}
+		case <-timer:
+			var mounts []string
+			for i := range pending {
+				mounts = append(mounts, i)
+			}
+			sort.Strings(mounts)
+			pending = make(map[string]bool)
+			timer = nil
+			run(mounts)
+		}
+	}
+}
+
+// Runs sync --on for those of mounts that hold group members
+func syncMounts(command []string, mounts []string) {
+	argv := append(append([]string{}, command...), "sync")
+	found := false
+	table, err := dirsig.Mounts()
+	if err != nil {
+		appLog.Warn("", "unable to read the mount table: %v", err)
+	}
+	for _, i := range mounts {
+		if m := dirsig.MountOf(table, i); m != nil && m.Mountpoint == i &&
+			dirsig.IsNetworkFilesystem(m.FSType) && !dirsig.DefaultSearch.NetworkFilesystems {
+			appLog.Info("", "not searching %s, a network filesystem", i)
+			continue
+		}
+		if len(dirsig.FindIn(i, *appSigFilename, *appDepth)) == 0 {
+			appLog.Debug("", "no group members on %s", i)
+			continue
+		}
+		argv = append(argv, "--on", i)
+		found = true
+	}
+	if !found {
+		return
+	}
+	argv = append(argv, *daemonSyncArgs...)
+	fmt.Println("Running", strings.Join(argv, " "))
+	appLog.Info("", "running %s", strings.Join(argv, " "))
+	a := []interface{}{}
+	for _, i := range argv[1:] {
+		a = append(a, i)
+	}
+	if err := sh.Command(argv[0], a...).Run(); err != nil {
+		fmt.Println("Sync failed:", err)
+		appLog.Warn("", "sync of %s failed: %v", strings.Join(mounts, ", "), err)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hypoactiv/autoannex/dirsig"
+)
+
+func TestDebounceMounts(t *testing.T) {
+	events := make(chan dirsig.MountEvent)
+	runs := make(chan []string, 10)
+	done := make(chan struct{})
+	go func() {
+		debounceMounts(events, 50*time.Millisecond, func(mounts []string) { runs <- mounts })
+		close(done)
+	}()
+	// A drive mounting several partitions, one of which goes away again
+	events <- dirsig.MountEvent{Mountpoint: "/media/user/disk1-b", Mounted: true}
+	events <- dirsig.MountEvent{Mountpoint: "/media/user/disk1-a", Mounted: true}
+	events <- dirsig.MountEvent{Mountpoint: "/media/user/disk1-c", Mounted: true}
+	events <- dirsig.MountEvent{Mountpoint: "/media/user/disk1-c", Mounted: false}
+	select {
+	case mounts := <-runs:
+		if strings.Join(mounts, " ") != "/media/user/disk1-a /media/user/disk1-b" {
+			t.Error("unexpected mounts", mounts)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no sync after mounts")
+	}
+	// A mount and unmount in quick succession triggers nothing
+	events <- dirsig.MountEvent{Mountpoint: "/media/user/disk2", Mounted: true}
+	events <- dirsig.MountEvent{Mountpoint: "/media/user/disk2", Mounted: false}
+	close(events)
+	<-done
+	if len(runs) != 0 {
+		t.Error("unexpected sync of", <-runs)
+	}
+}
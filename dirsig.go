@@ -1,14 +1,38 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path"
+	"time"
 
 	"github.com/go-yaml/yaml"
 	"github.com/hypoactiv/autoannex/dirsig"
 )
 
+// Configures which mount points discovery searches from the configuration
+// file. Mount points that are skipped are reported on stderr, as sig find's
+// output is read by other hosts.
+func configureDiscovery(config *Config) error {
+	c := config.Discovery
+	timeout := dirsig.DefaultStatTimeout
+	if c.StatTimeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(c.StatTimeout); err != nil {
+			return errors.New("invalid stat-timeout: " + err.Error())
+		}
+	}
+	s := dirsig.DefaultSearch
+	s.NetworkFilesystems = c.NetworkFilesystems
+	s.StatTimeout = timeout
+	s.Skipped = func(m dirsig.Mount, reason string) {
+		fmt.Fprintln(os.Stderr, "Not searching", m.Mountpoint+":", reason)
+		appLog.Info("", "not searching %s: %s", m.Mountpoint, reason)
+	}
+	return nil
+}
+
 // Starts a new group with a random UUID, and makes dir a member of the group
 func dirsigCmdNew() {
 	dir := *sigNewPath
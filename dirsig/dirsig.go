@@ -1,7 +1,6 @@
 package dirsig
 
 import (
-	"bufio"
 	"io/ioutil"
 	"os"
 	"os/user"
@@ -9,6 +8,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	uuid "github.com/nu7hatch/gouuid"
 )
@@ -46,24 +46,38 @@ func NewSignature(filename string) (s *Signature) {
 	return &Signature{UUID: u.String(), Filename: filename}
 }
 
-func hackExpandStringEscape(in string) string {
-	// /proc/mounts (thankfully) replaces spaces with \040, but
-	// this causes file not found errors later, so expand to spaces
-	return strings.Replace(in, "\\040", " ", -1)
-}
-
 // Recursively search all system mounts and the user's home folder for
 // signature files named 'filename' to a maximum depth of 'depth.' If
 // 'dirHint' is not "" only recurse into folders named 'dirHint'
 //
 // Returns a map of signature UUIDs to slices of paths sharing that signature
 func Find(filename string, dirHint string, depth uint) map[string][]string {
+	return find(filename, searchLocations(dirHint, depth))
+}
+
+// Like Find, but only searches 'root' and its subdirectories, for example a
+// newly mounted drive
+func FindIn(root string, filename string, depth uint) map[string][]string {
+	if err := statWithin(root, DefaultSearch.StatTimeout); err != nil {
+		DefaultSearch.skip(Mount{Mountpoint: root}, err.Error())
+		return map[string][]string{}
+	}
+	in := make(chan string, 1)
+	in <- root
+	close(in)
+	return find(filename, recurseSubdirectories(in, "", depth, nil, DefaultSearch))
+}
+
+// Looks for signature files in each of the locations 'm'
+func find(filename string, m <-chan string) map[string][]string {
 	// Map of maps so that duplicate paths only get recorded once
 	groups := make(map[Signature]map[string]struct{})
-	m := searchLocations(dirHint, depth)
 	for i := range m {
-		i = hackExpandStringEscape(i)
-		s, err := ReadSignature(i, filename)
+		var s *Signature
+		err := within(DefaultSearch.StatTimeout, func() (err error) {
+			s, err = ReadSignature(i, filename)
+			return
+		})
 		if err != nil {
 			continue
 		}
@@ -89,6 +103,10 @@ func Find(filename string, dirHint string, depth uint) map[string][]string {
 					}
 				}
 				os.Remove(tf.Name())
+			} else {
+				// Read-only, so fall back to comparing inodes, which
+				// catches bind mounts but not every kind of alias
+				removeSameFile(j, k)
 			}
 			groupsList[s.UUID] = make([]string, len(j))
 			i := 0
@@ -101,6 +119,22 @@ func Find(filename string, dirHint string, depth uint) map[string][]string {
 	return groupsList
 }
 
+// Removes the paths in j other than k that are the same directory as k
+func removeSameFile(j map[string]struct{}, k string) {
+	sk, err := os.Stat(k)
+	if err != nil {
+		return
+	}
+	for l := range j {
+		if l == k {
+			continue
+		}
+		if sl, err := os.Stat(l); err == nil && os.SameFile(sk, sl) {
+			delete(j, l)
+		}
+	}
+}
+
 // Writes the signature to the specified directory.
 func (s Signature) Write(dir string) (err error) {
 	err = ioutil.WriteFile(s.sigFile(dir), []byte(s.UUID+"\n"), 0644)
@@ -126,37 +160,60 @@ func (s *Signature) sigFile(dir string) string {
 }
 
 func searchLocations(dirHint string, depth uint) <-chan string {
+	o := DefaultSearch
+	mounts, err := Mounts()
+	if e, ok := err.(*MalformedError); ok {
+		for _, i := range e.Lines {
+			o.skip(Mount{Mountpoint: malformedMountpoint(i)}, "unable to parse mountinfo line "+i)
+		}
+	}
+	search, skipped := o.searchMounts(mounts)
+	exclude := make(map[string]bool)
+	for _, i := range skipped {
+		exclude[i] = true
+	}
 	out := make(chan string)
 	go func() {
 		defer close(out)
 		usr, err := user.Current()
-		if err == nil {
+		if err == nil && o.searchHome(mounts, usr.HomeDir) {
 			out <- usr.HomeDir
 		}
-		m := enumerateMountpoints()
-		for s := range m {
+		for _, s := range search {
 			out <- s
 		}
 	}()
-	return recurseSubdirectories(out, dirHint, depth)
+	return recurseSubdirectories(out, dirHint, depth, exclude, o)
 }
 
-func recurseSubdirectories(in <-chan string, dirHint string, depth uint) <-chan string {
+// Walks each directory from 'in' to a maximum depth of 'depth', without
+// entering the directories in 'exclude'. Once listing a directory times out,
+// the rest of that walk is skipped.
+func recurseSubdirectories(in <-chan string, dirHint string, depth uint, exclude map[string]bool, o *SearchOptions) <-chan string {
 	out := make(chan string)
 	go func() {
 		defer close(out)
 		wg := sync.WaitGroup{}
-		for i := range in {
-			i = hackExpandStringEscape(i)
+		for root := range in {
+			var hung int32
 			var r func(string, uint)
 			r = func(i string, d uint) {
 				defer wg.Done()
+				if atomic.LoadInt32(&hung) != 0 {
+					return
+				}
 				out <- i
 				if d == 0 {
 					return
 				}
 				d--
-				files, err := ioutil.ReadDir(i)
+				files, err := readDirWithin(i, o.StatTimeout)
+				if _, ok := err.(timeoutError); ok {
+					if atomic.CompareAndSwapInt32(&hung, 0, 1) {
+						o.skip(Mount{Mountpoint: root}, "listing "+i+" "+err.Error())
+					}
+					return
+				}
 				if err != nil {
 					return
 				}
@@ -167,47 +224,23 @@ func recurseSubdirectories(in <-chan string, dirHint string, depth uint) <-chan
 							// matching the hint
 							continue
 						}
-						wg.Add(1)
+						sub := i + "/" + f.Name()
 						if i[len(i)-1] == '/' {
-							go r(i+f.Name(), d)
-						} else {
-							go r(i+"/"+f.Name(), d)
+							sub = i + f.Name()
+						}
+						if exclude[sub] {
+							// A skipped mount point
+							continue
 						}
+						wg.Add(1)
+						go r(sub, d)
 					}
 				}
 			}
 			wg.Add(1)
-			go r(i, depth)
+			go r(root, depth)
 			wg.Wait()
 		}
 	}()
 	return out
 }
-
-func enumerateMountpoints() <-chan string {
-	out := make(chan string)
-	go func() {
-		defer close(out)
-		// Read system mounts from /proc/mounts (linux only)
-		f, err := os.Open("/proc/mounts")
-		if err != nil {
-			return
-		}
-		b := bufio.NewReader(f)
-		for {
-			line, err := b.ReadString('\n')
-			if err != nil {
-				// End of file
-				break
-			}
-			line = strings.Trim(line, "\r\n")
-			c := strings.SplitN(line, " ", 4)
-			if _, ok := ignoreFilesystems[c[2]]; ok {
-				// Ignored filesystem type
-				continue
-			}
-			out <- c[1]
-		}
-	}()
-	return out
-}
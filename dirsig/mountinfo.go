@@ -0,0 +1,203 @@
+package dirsig
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// File listing the mounts visible to this process (linux only)
+const mountinfoPath = "/proc/self/mountinfo"
+
+// A mounted filesystem, as listed in /proc/self/mountinfo
+type Mount struct {
+	// Unique ID of the mount, and of the mount it is mounted on
+	ID       int
+	ParentID int
+	// Device number of the filesystem
+	Major int
+	Minor int
+	// Directory within the filesystem that is mounted. "/" unless this is a
+	// bind mount of a subdirectory.
+	Root       string
+	Mountpoint string
+	// Options of this mount, eg "rw" and "noatime"
+	Options []string
+	// Filesystem type, eg "ext4" or "fuse.sshfs"
+	FSType string
+	// Where the filesystem comes from, eg "/dev/sdb1", or "none"
+	Source string
+	// Options of the filesystem, shared by every mount of it
+	SuperOptions []string
+}
+
+// Returns the filesystem's device number, as "major:minor"
+func (m *Mount) Device() string {
+	return strconv.Itoa(m.Major) + ":" + strconv.Itoa(m.Minor)
+}
+
+// A filesystem being mounted or unmounted
+type MountEvent struct {
+	Mountpoint string
+	// True if the filesystem was mounted, false if it was unmounted
+	Mounted bool
+	// The mount that was added or removed
+	Mount Mount
+}
+
+// Returns true if the mount can't be written to, either because it was
+// mounted read-only or because its filesystem was
+func (m *Mount) ReadOnly() bool {
+	return hasOption(m.Options, "ro") || hasOption(m.SuperOptions, "ro")
+}
+
+func hasOption(options []string, option string) bool {
+	for _, i := range options {
+		if i == option {
+			return true
+		}
+	}
+	return false
+}
+
+// Error reporting lines of mountinfo that couldn't be parsed. The mounts on
+// the other lines are still returned.
+type MalformedError struct {
+	Lines []string
+}
+
+func (e *MalformedError) Error() string {
+	return fmt.Sprintf("skipped %d malformed mountinfo line(s), first %q", len(e.Lines), e.Lines[0])
+}
+
+// Returns the mount point of a malformed mountinfo line, or "" if it hasn't
+// got one
+func malformedMountpoint(line string) string {
+	if c := strings.Split(line, " "); len(c) > 4 {
+		return unescapeMountField(c[4])
+	}
+	return ""
+}
+
+// Returns the mounts visible to this process, in the order they were
+// mounted. If some lines of the mount table can't be parsed, the other
+// mounts are returned with a *MalformedError.
+func Mounts() (mounts []Mount, err error) {
+	f, err := os.Open(mountinfoPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseMountinfo(f)
+}
+
+// Returns the mount holding path, which is the mount with the longest
+// mountpoint containing it
+func MountOf(mounts []Mount, path string) (m *Mount) {
+	path = filepath.Clean(path)
+	for i := range mounts {
+		mp := mounts[i].Mountpoint
+		if path != mp && mp != "/" && !strings.HasPrefix(path, mp+"/") {
+			continue
+		}
+		if m == nil || len(mp) >= len(m.Mountpoint) {
+			// Later mounts on the same mountpoint hide earlier ones
+			m = &mounts[i]
+		}
+	}
+	return
+}
+
+func parseMountinfo(r io.Reader) (mounts []Mount, err error) {
+	s := bufio.NewScanner(r)
+	var malformed []string
+	for s.Scan() {
+		if s.Text() == "" {
+			continue
+		}
+		m, err := parseMountinfoLine(s.Text())
+		if err != nil {
+			// Don't lose the whole table to one line in a format this
+			// doesn't understand
+			malformed = append(malformed, s.Text())
+			continue
+		}
+		mounts = append(mounts, m)
+	}
+	if err = s.Err(); err != nil {
+		return nil, err
+	}
+	if len(malformed) > 0 {
+		return mounts, &MalformedError{Lines: malformed}
+	}
+	return mounts, nil
+}
+
+// Parses a line of mountinfo, for example
+//
+//	36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+//
+// Any number of optional fields, like "master:1", come before the "-".
+func parseMountinfoLine(line string) (m Mount, err error) {
+	c := strings.Split(line, " ")
+	sep := -1
+	for i := 6; i < len(c); i++ {
+		if c[i] == "-" {
+			sep = i
+			break
+		}
+	}
+	if sep < 0 || len(c) < sep+4 {
+		return m, errors.New("malformed mountinfo line: " + line)
+	}
+	if m.ID, err = strconv.Atoi(c[0]); err != nil {
+		return
+	}
+	if m.ParentID, err = strconv.Atoi(c[1]); err != nil {
+		return
+	}
+	dev := strings.SplitN(c[2], ":", 2)
+	if len(dev) != 2 {
+		return m, errors.New("malformed device number in mountinfo line: " + line)
+	}
+	if m.Major, err = strconv.Atoi(dev[0]); err != nil {
+		return
+	}
+	if m.Minor, err = strconv.Atoi(dev[1]); err != nil {
+		return
+	}
+	m.Root = unescapeMountField(c[3])
+	m.Mountpoint = unescapeMountField(c[4])
+	m.Options = strings.Split(c[5], ",")
+	m.FSType = unescapeMountField(c[sep+1])
+	m.Source = unescapeMountField(c[sep+2])
+	m.SuperOptions = strings.Split(c[sep+3], ",")
+	return m, nil
+}
+
+// Decodes the octal escapes, eg "\040" for a space, that the kernel uses for
+// whitespace and backslashes in mountinfo fields
+func unescapeMountField(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && isOctal(s[i+1]) && isOctal(s[i+2]) && isOctal(s[i+3]) {
+			b.WriteByte((s[i+1]-'0')<<6 | (s[i+2]-'0')<<3 | (s[i+3] - '0'))
+			i += 3
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+func isOctal(c byte) bool {
+	return c >= '0' && c <= '7'
+}
@@ -0,0 +1,79 @@
+package dirsig
+
+import (
+	"strings"
+	"testing"
+)
+
+const testMountinfo = `22 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw,errors=remount-ro
+25 22 0:5 / /proc rw,nosuid,nodev,noexec,relatime shared:12 - proc proc rw
+40 22 8:17 / /media/user/my\040disk rw,nosuid,nodev,relatime shared:30 - exfat /dev/sdb1 rw,fmask=0022
+41 22 8:17 /photos /home/user/photos ro,relatime shared:30 master:2 - exfat /dev/sdb1 rw,fmask=0022
+42 22 0:50 / /mnt/tab\011back\134slash\012newline rw - fuse.sshfs user@host:/srv\040annex rw,user_id=1000
+`
+
+func TestParseMountinfo(t *testing.T) {
+	mounts, err := parseMountinfo(strings.NewReader(testMountinfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mounts) != 5 {
+		t.Fatal("expected 5 mounts, got", len(mounts))
+	}
+	m := mounts[2]
+	if m.ID != 40 || m.ParentID != 22 || m.Device() != "8:17" || m.Root != "/" ||
+		m.Mountpoint != "/media/user/my disk" || m.FSType != "exfat" || m.Source != "/dev/sdb1" ||
+		m.Options[0] != "rw" || m.SuperOptions[1] != "fmask=0022" {
+		t.Errorf("unexpected mount %+v", m)
+	}
+	// Optional fields are skipped
+	if m = mounts[3]; m.Root != "/photos" || m.FSType != "exfat" || m.Options[0] != "ro" {
+		t.Errorf("unexpected bind mount %+v", m)
+	}
+	if mounts[2].ReadOnly() || !mounts[3].ReadOnly() {
+		t.Error("expected only the bind mount to be read-only")
+	}
+	if m = mounts[4]; m.Mountpoint != "/mnt/tab\tback\\slash\nnewline" || m.Source != "user@host:/srv annex" {
+		t.Errorf("unexpected escaped mount %q from %q", m.Mountpoint, m.Source)
+	}
+	// Malformed lines are skipped, keeping the rest of the table
+	mounts, err = parseMountinfo(strings.NewReader("1 2 3:4 / /mnt/bad\n" + testMountinfo))
+	if e, ok := err.(*MalformedError); !ok || len(e.Lines) != 1 || malformedMountpoint(e.Lines[0]) != "/mnt/bad" {
+		t.Error("expected the malformed line to be reported, got", err)
+	}
+	if len(mounts) != 5 {
+		t.Error("expected the other 5 mounts, got", len(mounts))
+	}
+}
+
+func TestMountOf(t *testing.T) {
+	mounts, err := parseMountinfo(strings.NewReader(testMountinfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for path, mountpoint := range map[string]string{
+		"/home/user/annex":             "/",
+		"/media/user/my disk":          "/media/user/my disk",
+		"/media/user/my disk/annex":    "/media/user/my disk",
+		"/media/user/my diskette":      "/",
+		"/home/user/photos/2020/annex": "/home/user/photos",
+	} {
+		if m := MountOf(mounts, path); m == nil || m.Mountpoint != mountpoint {
+			t.Errorf("MountOf(%q) = %+v, expected %q", path, m, mountpoint)
+		}
+	}
+}
+
+func TestUnescapeMountField(t *testing.T) {
+	for in, out := range map[string]string{
+		`plain`:       "plain",
+		`a\040b`:      "a b",
+		`\134\011`:    "\\\t",
+		`trailing\04`: `trailing\04`,
+		`not\8octal`:  `not\8octal`,
+	} {
+		if u := unescapeMountField(in); u != out {
+			t.Errorf("unescapeMountField(%q) = %q, expected %q", in, u, out)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+//go:build linux
+// +build linux
+
+package dirsig
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// How often a MountWatcher checks whether it has been closed
+const watchInterval = time.Second
+
+// Reports filesystems as they are mounted and unmounted, without polling
+type MountWatcher struct {
+	// Receives an event for each change to the mount table. Closed when the
+	// watcher is closed or fails.
+	Events <-chan MountEvent
+	// The error that stopped the watcher, if any, once Events is closed
+	Err    error
+	f      *os.File
+	done   chan struct{}
+	closed chan struct{}
+}
+
+// Starts watching the mount table
+func WatchMounts() (w *MountWatcher, err error) {
+	// The kernel flags mountinfo with POLLPRI whenever the mount table
+	// changes
+	f, err := os.Open(mountinfoPath)
+	if err != nil {
+		return nil, err
+	}
+	mounts, err := readMountpoints(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	events := make(chan MountEvent)
+	w = &MountWatcher{Events: events, f: f, done: make(chan struct{}), closed: make(chan struct{})}
+	go w.watch(events, mounts)
+	return w, nil
+}
+
+// Stops the watcher and closes Events
+func (w *MountWatcher) Close() error {
+	close(w.done)
+	<-w.closed
+	return w.f.Close()
+}
+
+func (w *MountWatcher) watch(events chan<- MountEvent, mounts map[string]Mount) {
+	defer close(w.closed)
+	defer close(events)
+	fd := int(w.f.Fd())
+	for {
+		select {
+		case <-w.done:
+			return
+		default:
+		}
+		// The kernel signals mount table changes as exceptional conditions
+		var except syscall.FdSet
+		fdSet(&except, fd)
+		tv := syscall.NsecToTimeval(int64(watchInterval))
+		n, err := syscall.Select(fd+1, nil, nil, &except, &tv)
+		if err == syscall.EINTR || n == 0 {
+			continue
+		}
+		if err != nil {
+			w.Err = err
+			return
+		}
+		current, err := readMountpoints(w.f)
+		if err != nil {
+			w.Err = err
+			return
+		}
+		for _, e := range diffMounts(mounts, current) {
+			select {
+			case events <- e:
+			case <-w.done:
+				return
+			}
+		}
+		mounts = current
+	}
+}
+
+// Adds fd to set
+func fdSet(set *syscall.FdSet, fd int) {
+	// The width of FdSet's words varies by architecture
+	bits := int(unsafe.Sizeof(set.Bits[0])) * 8
+	set.Bits[fd/bits] |= 1 << uint(fd%bits)
+}
+
+// Rereads the mounts listed in the open mountinfo file f, keyed by mount
+// point
+func readMountpoints(f *os.File) (mounts map[string]Mount, err error) {
+	if _, err = f.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	// Read it all at once, as the kernel only guarantees a consistent view
+	// within a single read
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	list, err := parseMountinfo(bytes.NewReader(b))
+	if _, ok := err.(*MalformedError); !ok && err != nil {
+		return nil, err
+	}
+	mounts = make(map[string]Mount)
+	for _, i := range list {
+		mounts[i.Mountpoint] = i
+	}
+	return mounts, nil
+}
+
+// Returns the events that turn the mount table old into new
+func diffMounts(old, new map[string]Mount) (events []MountEvent) {
+	for i, m := range old {
+		if _, ok := new[i]; !ok {
+			events = append(events, MountEvent{Mountpoint: i, Mounted: false, Mount: m})
+		}
+	}
+	for i, m := range new {
+		if _, ok := old[i]; !ok {
+			events = append(events, MountEvent{Mountpoint: i, Mounted: true, Mount: m})
+		}
+	}
+	return
+}
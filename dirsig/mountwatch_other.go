@@ -0,0 +1,23 @@
+//go:build !linux
+// +build !linux
+
+package dirsig
+
+import "errors"
+
+// Reports filesystems as they are mounted and unmounted. Only supported on
+// linux.
+type MountWatcher struct {
+	Events <-chan MountEvent
+	Err    error
+}
+
+// Fails, as the mount table can only be watched on linux
+func WatchMounts() (w *MountWatcher, err error) {
+	return nil, errors.New("watching mounts is only supported on linux")
+}
+
+// Stops the watcher and closes Events
+func (w *MountWatcher) Close() error {
+	return nil
+}
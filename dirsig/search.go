@@ -0,0 +1,173 @@
+package dirsig
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+// Filesystems reached over the network. Searching one can hang for minutes
+// while its server is unreachable.
+var networkFilesystems = map[string]struct{}{
+	"9p":             struct{}{},
+	"afs":            struct{}{},
+	"ceph":           struct{}{},
+	"cifs":           struct{}{},
+	"fuse.rclone":    struct{}{},
+	"fuse.s3fs":      struct{}{},
+	"fuse.sshfs":     struct{}{},
+	"glusterfs":      struct{}{},
+	"fuse.glusterfs": struct{}{},
+	"ncpfs":          struct{}{},
+	"nfs":            struct{}{},
+	"nfs4":           struct{}{},
+	"smb3":           struct{}{},
+	"smbfs":          struct{}{},
+	"sshfs":          struct{}{},
+}
+
+// Returns true if fstype, as listed in mountinfo, is a network filesystem
+func IsNetworkFilesystem(fstype string) bool {
+	_, ok := networkFilesystems[fstype]
+	return ok
+}
+
+// How long to wait for a mount point to respond, unless configured otherwise
+const DefaultStatTimeout = 5 * time.Second
+
+// Controls which mount points are searched
+type SearchOptions struct {
+	// Whether to search network filesystems such as NFS, CIFS and sshfs
+	NetworkFilesystems bool
+	// How long to wait for a mount point to respond before skipping it. Zero
+	// waits forever.
+	StatTimeout time.Duration
+	// If not nil, called with each mount point that isn't searched and why
+	Skipped func(m Mount, reason string)
+}
+
+// Settings used by Find and FindIn
+var DefaultSearch = &SearchOptions{StatTimeout: DefaultStatTimeout}
+
+func (o *SearchOptions) skip(m Mount, reason string) {
+	if o.Skipped != nil {
+		o.Skipped(m, reason)
+	}
+}
+
+// Filesystem calls made while searching. Replaced in tests.
+var (
+	stat    = os.Stat
+	readDir = ioutil.ReadDir
+)
+
+// Error of a filesystem call that took too long
+type timeoutError time.Duration
+
+func (e timeoutError) Error() string {
+	return fmt.Sprintf("timed out after %v", time.Duration(e))
+}
+
+// Runs f, giving up after timeout. f is left running if it times out, as a
+// call stuck on an unreachable server can't be interrupted.
+func within(timeout time.Duration, f func() error) error {
+	if timeout <= 0 {
+		return f()
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-time.After(timeout):
+		return timeoutError(timeout)
+	}
+}
+
+// Stats path, giving up after timeout
+func statWithin(path string, timeout time.Duration) error {
+	return within(timeout, func() error {
+		_, err := stat(path)
+		return err
+	})
+}
+
+// Lists the directory dir, giving up after timeout
+func readDirWithin(dir string, timeout time.Duration) (files []os.FileInfo, err error) {
+	done := make(chan []os.FileInfo, 1)
+	err = within(timeout, func() error {
+		files, err := readDir(dir)
+		done <- files
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return <-done, nil
+}
+
+// Returns true if the user's home directory, home, should be searched. It
+// is skipped like the mount points in mounts that hold it.
+func (o *SearchOptions) searchHome(mounts []Mount, home string) bool {
+	if m := MountOf(mounts, home); m != nil && IsNetworkFilesystem(m.FSType) && !o.NetworkFilesystems {
+		o.skip(Mount{Mountpoint: home, FSType: m.FSType}, "home directory is on "+m.FSType+", a network filesystem")
+		return false
+	}
+	if err := statWithin(home, o.StatTimeout); err != nil {
+		o.skip(Mount{Mountpoint: home}, err.Error())
+		return false
+	}
+	return true
+}
+
+// Returns the mount points in mounts to search, skipping virtual
+// filesystems, duplicate bind mounts of the same directory, network
+// filesystems unless enabled, and mount points that don't respond. Mount
+// points skipped for being on the network or unresponsive are returned in
+// skipped, so that searches of other mounts don't walk into them.
+func (o *SearchOptions) searchMounts(mounts []Mount) (search, skipped []string) {
+	seen := make(map[string]bool)
+	var candidates []Mount
+	for _, m := range mounts {
+		if _, ok := ignoreFilesystems[m.FSType]; ok {
+			// Ignored filesystem type
+			continue
+		}
+		if IsNetworkFilesystem(m.FSType) && !o.NetworkFilesystems {
+			o.skip(m, m.FSType+" is a network filesystem")
+			skipped = append(skipped, m.Mountpoint)
+			continue
+		}
+		if seen[m.Device()+m.Root] {
+			// Already searched through another mount point
+			continue
+		}
+		seen[m.Device()+m.Root] = true
+		candidates = append(candidates, m)
+	}
+	// Check every mount point at once, so that several unresponsive ones
+	// only cost a single timeout
+	errs := make([]error, len(candidates))
+	wg := sync.WaitGroup{}
+	for i := range candidates {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = statWithin(candidates[i].Mountpoint, o.StatTimeout)
+		}(i)
+	}
+	wg.Wait()
+	for i, m := range candidates {
+		if errs[i] != nil {
+			o.skip(m, errs[i].Error())
+			skipped = append(skipped, m.Mountpoint)
+			continue
+		}
+		search = append(search, m.Mountpoint)
+	}
+	return
+}
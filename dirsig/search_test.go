@@ -0,0 +1,143 @@
+package dirsig
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSearchMounts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dirsig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	local, share, hung := filepath.Join(dir, "local"), filepath.Join(dir, "share"), filepath.Join(dir, "hung")
+	for _, i := range []string{local, share, hung} {
+		if err = os.Mkdir(i, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(f func(string) (os.FileInfo, error)) { stat = f }(stat)
+	block, called := make(chan struct{}), make(chan struct{}, 1)
+	defer close(block)
+	stat = func(path string) (os.FileInfo, error) {
+		if path == hung {
+			// An unreachable server
+			called <- struct{}{}
+			<-block
+		}
+		return os.Stat(path)
+	}
+	mounts := []Mount{
+		{Major: 8, Minor: 1, Root: "/", Mountpoint: local, FSType: "ext4"},
+		{Major: 0, Minor: 5, Root: "/", Mountpoint: "/proc", FSType: "proc"},
+		{Major: 0, Minor: 50, Root: "/", Mountpoint: share, FSType: "nfs4"},
+		{Major: 0, Minor: 51, Root: "/", Mountpoint: hung, FSType: "ext4"},
+		{Major: 0, Minor: 52, Root: "/", Mountpoint: filepath.Join(dir, "gone"), FSType: "ext4"},
+	}
+	reasons := make(map[string]string)
+	o := &SearchOptions{StatTimeout: 50 * time.Millisecond, Skipped: func(m Mount, reason string) {
+		reasons[m.Mountpoint] = reason
+	}}
+	search, skipped := o.searchMounts(mounts)
+	if len(search) != 1 || search[0] != local {
+		t.Error("unexpected mount points to search", search)
+	}
+	if len(skipped) != 3 || !strings.Contains(reasons[share], "network") ||
+		!strings.Contains(reasons[hung], "timed out") || reasons[filepath.Join(dir, "gone")] == "" {
+		t.Error("unexpected skipped mount points", skipped, reasons)
+	}
+	// Network filesystems can be searched if enabled
+	o.NetworkFilesystems = true
+	<-called
+	if search, _ = o.searchMounts(mounts); len(search) != 2 || search[1] != share {
+		t.Error("expected to search the network filesystem, got", search)
+	}
+	// Before stat is restored, wait for the hung stats to have started
+	<-called
+}
+
+func TestRecurseExclude(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dirsig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, i := range []string{"a", "share/b"} {
+		if err = os.MkdirAll(filepath.Join(dir, i), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	in := make(chan string, 1)
+	in <- dir
+	close(in)
+	var found []string
+	for i := range recurseSubdirectories(in, "", 2, map[string]bool{filepath.Join(dir, "share"): true}, &SearchOptions{}) {
+		found = append(found, i)
+	}
+	if len(found) != 2 {
+		t.Error("expected to find the root and a, found", found)
+	}
+}
+
+func TestRecurseTimeout(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dirsig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, i := range []string{"a/hung/x", "b/c"} {
+		if err = os.MkdirAll(filepath.Join(dir, i), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	defer func(f func(string) ([]os.FileInfo, error)) { readDir = f }(readDir)
+	block, called := make(chan struct{}), make(chan struct{}, 1)
+	defer close(block)
+	readDir = func(path string) ([]os.FileInfo, error) {
+		if path == filepath.Join(dir, "a", "hung") {
+			// A server that stopped responding after the first stat
+			called <- struct{}{}
+			<-block
+		}
+		return ioutil.ReadDir(path)
+	}
+	var reason string
+	o := &SearchOptions{StatTimeout: 50 * time.Millisecond, Skipped: func(m Mount, r string) {
+		reason = r
+	}}
+	in := make(chan string, 1)
+	in <- dir
+	close(in)
+	found := make(map[string]bool)
+	for i := range recurseSubdirectories(in, "", 3, nil, o) {
+		found[i] = true
+	}
+	if found[filepath.Join(dir, "a", "hung", "x")] || !strings.Contains(reason, "timed out") {
+		t.Error("expected the hung directory to be skipped, found", found, reason)
+	}
+	// Before readDir is restored
+	<-called
+}
+
+func TestSearchHome(t *testing.T) {
+	mounts := []Mount{
+		{Mountpoint: "/", FSType: "ext4"},
+		{Mountpoint: "/home", FSType: "nfs4"},
+	}
+	var skipped []string
+	o := &SearchOptions{Skipped: func(m Mount, reason string) {
+		skipped = append(skipped, m.Mountpoint)
+	}}
+	if o.searchHome(mounts, "/home/user") || len(skipped) != 1 || skipped[0] != "/home/user" {
+		t.Error("expected a home directory on NFS to be skipped", skipped)
+	}
+	o.NetworkFilesystems = true
+	if home, err := os.UserHomeDir(); err == nil && !o.searchHome(mounts, home) {
+		t.Error("expected the home directory to be searched")
+	}
+}
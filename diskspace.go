@@ -0,0 +1,104 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/hypoactiv/autoannex/goannex"
+)
+
+// Most files passed to a single git-annex get, to stay well within the
+// limits on command line length
+const maxPathsPerCommand = 1000
+
+// Returns the space available to unprivileged users on the filesystem
+// holding path
+var freeSpace = availableSpace
+
+// Parses a size such as "500M" or "10GiB" into bytes. Suffixes are powers of
+// 1024, and a plain number is in bytes.
+func parseSize(s string) (int64, error) {
+	units := map[byte]int64{'K': 1 << 10, 'M': 1 << 20, 'G': 1 << 30, 'T': 1 << 40}
+	t := strings.ToUpper(strings.TrimSpace(s))
+	t = strings.TrimSuffix(t, "B")
+	mult := int64(1)
+	if strings.HasSuffix(t, "I") {
+		t = strings.TrimSuffix(t, "I")
+		if n := len(t); n == 0 || units[t[n-1]] == 0 {
+			return 0, errors.New("invalid size " + s)
+		}
+	}
+	if n := len(t); n > 0 && units[t[n-1]] != 0 {
+		mult = units[t[n-1]]
+		t = t[:n-1]
+	}
+	n, err := strconv.ParseFloat(strings.TrimSpace(t), 64)
+	if err != nil || n < 0 {
+		return 0, errors.New("invalid size " + s)
+	}
+	return int64(n * float64(mult)), nil
+}
+
+// Checks that the content a get step would fetch into r fits on the
+// member's filesystem, leaving reserve bytes free. Returns the step to run,
+// which is limited to the files that fit if not all of them do, or nil if
+// none fit. Files of unknown size are left out of a limited get, and
+// otherwise make the check approximate. note describes any limit or
+// approximation for the summary.
+func guardGet(r *goannex.Repo, s *StepConfig, reserve int64) (run *StepConfig, note string) {
+	o := s.transferOptions()
+	o.Auto = s.auto()
+	missing, err := r.Missing(o)
+	if err != nil {
+		appLog.Warn(r.Path, "unable to list missing content, not checking free space: %v", err)
+		return s, ""
+	}
+	free, err := freeSpace(r.Path)
+	if err != nil {
+		appLog.Warn(r.Path, "unable to check free space: %v", err)
+		return s, ""
+	}
+	var need int64
+	unknown := 0
+	for _, i := range missing {
+		need += int64(i.Size)
+		if !i.SizeKnown {
+			unknown++
+		}
+	}
+	budget := free - reserve
+	if need <= budget {
+		appLog.Debug(r.Path, "%s to get, %s free", humanBytes(need), humanBytes(free))
+		if unknown > 0 {
+			note = fmt.Sprintf("free space check is approximate, as %d file(s) are of unknown size", unknown)
+			appLog.Warn(r.Path, "%s", note)
+		}
+		return s, note
+	}
+	var fit []string
+	var used int64
+	for _, i := range missing {
+		// A file of unknown size might use up the reserve
+		if i.SizeKnown && used+int64(i.Size) <= budget {
+			fit = append(fit, i.File)
+			used += int64(i.Size)
+		}
+	}
+	note = fmt.Sprintf("%d of %d files (%s of %s) fit in %s free, keeping %s reserved",
+		len(fit), len(missing), humanBytes(used), humanBytes(need), humanBytes(free), humanBytes(reserve))
+	if unknown > 0 {
+		note += fmt.Sprintf(", leaving out %d file(s) of unknown size", unknown)
+	}
+	fmt.Println("Not enough space in", r.Path+":", note)
+	appLog.Warn(r.Path, "not enough space to get all wanted content: %s", note)
+	if len(fit) == 0 {
+		return nil, note
+	}
+	limited := *s
+	limited.Paths = fit
+	auto := false
+	limited.Auto = &auto
+	return &limited, note
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/hypoactiv/autoannex/dirsig"
+	"github.com/hypoactiv/autoannex/goannex"
+)
+
+// Filesystems without symlinks, on which git-annex needs an adjusted branch
+// with every file unlocked. ntfs-3g and exfat-fuse are listed as fuseblk.
+var crippledFilesystems = map[string]bool{
+	"vfat":    true,
+	"msdos":   true,
+	"exfat":   true,
+	"ntfs":    true,
+	"ntfs3":   true,
+	"fuseblk": true,
+}
+
+// Returns true if the mount m is a filesystem without symlinks
+func isCrippled(m *dirsig.Mount) bool {
+	return m != nil && crippledFilesystems[m.FSType]
+}
+
+// Returns true if the mount m can't be written to, so a member on it can
+// only be a source for the rest of its group
+func isReadOnly(m *dirsig.Mount) bool {
+	return m != nil && m.ReadOnly()
+}
+
+// Returns the mount holding each of the local members repos, keyed by path.
+// Members whose mount can't be found are left out.
+func memberMounts(repos []string) map[string]*dirsig.Mount {
+	mounts, err := dirsig.Mounts()
+	if err != nil {
+		appLog.Warn("", "unable to read the mount table: %v", err)
+		if mounts == nil {
+			return nil
+		}
+	}
+	found := make(map[string]*dirsig.Mount)
+	for _, i := range repos {
+		if m := dirsig.MountOf(mounts, i); m != nil {
+			found[i] = m
+			if m.ReadOnly() {
+				// Its own log can't be written either
+				appLog.skipRepoLog(i)
+			}
+			appLog.Debug(i, "on %s filesystem mounted at %s", m.FSType, m.Mountpoint)
+		}
+	}
+	return found
+}
+
+// Returns true if s is a command step that unlocks files, as either
+// git-annex unlock or git annex unlock, which does nothing useful on a
+// filesystem where every file is already unlocked
+func isUnlockStep(s *StepConfig) bool {
+	if s.Name != stepCommand {
+		return false
+	}
+	c := s.Command
+	return c[0] == "git-annex" && c[1] == "unlock" || c[0] == "git" && len(c) > 2 && c[1] == "annex" && c[2] == "unlock"
+}
+
+// Checks that r, a member on a filesystem without symlinks, has an adjusted
+// branch checked out. If adjust is set and it hasn't, switches to one,
+// returning the outcome as a step; otherwise a warning suggests doing so.
+func checkAdjusted(r *goannex.Repo, m *dirsig.Mount, adjust bool) *stepResult {
+	adjusted, err := r.Adjusted()
+	if err != nil {
+		appLog.Warn(r.Path, "unable to find the checked out branch: %v", err)
+		return nil
+	}
+	if adjusted {
+		return nil
+	}
+	if !adjust {
+		fmt.Println("warning:", r.Path, "is on", m.FSType, "which doesn't support symlinks. Run git annex adjust --unlock in it, or set adjust in its configuration")
+		appLog.Warn(r.Path, "on %s without an adjusted branch, run git annex adjust --unlock", m.FSType)
+		return nil
+	}
+	fmt.Println("Switching", r.Path, "on", m.FSType, "to an adjusted unlocked branch ...")
+	err = r.AdjustUnlock()
+	logStep(r.Path, "adjust", 0, err)
+	return &stepResult{step: "adjust", err: err}
+}
+
+// Returns notes on how the member on mount m is handled, for status
+func filesystemNotes(m *dirsig.Mount) (notes []string) {
+	if isCrippled(m) {
+		notes = append(notes, "no symlinks, needs an adjusted unlocked branch")
+	}
+	if isReadOnly(m) {
+		notes = append(notes, "read-only, only used as a source")
+	}
+	if m != nil && dirsig.IsNetworkFilesystem(m.FSType) {
+		notes = append(notes, "network filesystem")
+	}
+	return
+}
+
+// Prints each member of the selected groups with its filesystem, for the
+// status command
+func statusCmdShow() {
+	groups := dirsig.Find(*appSigFilename, "", *appDepth)
+	uuids := *statusUuids
+	if len(uuids) == 0 {
+		for i := range groups {
+			uuids = append(uuids, i)
+		}
+		sort.Strings(uuids)
+	}
+	var repos []string
+	for _, i := range uuids {
+		repos = append(repos, groups[i]...)
+	}
+	mounts := memberMounts(repos)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "GROUP\tMEMBER\tFILESYSTEM\tMOUNTPOINT\tBRANCH\tNOTES")
+	for _, g := range uuids {
+		if len(groups[g]) == 0 {
+			fmt.Fprintf(w, "%s\t(no members found)\t\t\t\t\n", g)
+			continue
+		}
+		for _, i := range groups[g] {
+			fstype, mountpoint := "?", "?"
+			m := mounts[i]
+			if m != nil {
+				fstype, mountpoint = m.FSType, m.Mountpoint
+			}
+			notes := filesystemNotes(m)
+			branch := "?"
+			if r, err := goannex.DefaultRunner.OpenRepo(i); err == nil {
+				if b, err := r.CurrentBranch(); err == nil {
+					branch = b
+				}
+				if c, err := r.Crippled(); err == nil && c && !isCrippled(m) {
+					notes = append(notes, "git-annex found no symlink support")
+				}
+				if isCrippled(m) && !isReadOnly(m) && !strings.HasPrefix(branch, "adjusted/") {
+					notes = append(notes, "run git annex adjust --unlock")
+				}
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", g, i, fstype, mountpoint, branch, strings.Join(notes, "; "))
+		}
+	}
+	w.Flush()
+}
@@ -0,0 +1,10 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux
+// +build !darwin,!dragonfly,!freebsd,!linux
+
+package main
+
+import "errors"
+
+func availableSpace(path string) (int64, error) {
+	return 0, errors.New("checking free space is not supported on this system")
+}
@@ -0,0 +1,29 @@
+package goannex
+
+import "strings"
+
+// Returns the name of the checked out branch, eg "master" or
+// "adjusted/master(unlocked)"
+func (r *Repo) CurrentBranch() (string, error) {
+	return r.cmdString("git", "symbolic-ref", "--short", "HEAD")
+}
+
+// Returns true if one of git-annex's adjusted branches is checked out
+func (r *Repo) Adjusted() (adjusted bool, err error) {
+	branch, err := r.CurrentBranch()
+	return strings.HasPrefix(branch, "adjusted/"), err
+}
+
+// Checks out an adjusted branch in which every annexed file is unlocked, as
+// suits filesystems without symlinks
+func (r *Repo) AdjustUnlock() (err error) {
+	err = r.cmdNoPanic("git-annex", "adjust", "--unlock")
+	return
+}
+
+// Returns true if git-annex found that the repository's filesystem doesn't
+// support symlinks, hard links or file permissions
+func (r *Repo) Crippled() (crippled bool, err error) {
+	out, err := r.cmdString("git", "config", "--bool", "--default", "false", "annex.crippledfilesystem")
+	return out == "true", err
+}
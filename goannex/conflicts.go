@@ -0,0 +1,29 @@
+package goannex
+
+import (
+	"bytes"
+)
+
+// Returns the paths of files with unresolved merge conflicts
+func (r *Repo) Conflicts() (paths []string, err error) {
+	out, err := r.cmdOutput("git", "diff", "--name-only", "--diff-filter=U", "-z")
+	return splitNull(out), err
+}
+
+// Returns the paths of variant files, which git-annex creates when it
+// resolves a merge conflict by keeping both versions, eg
+// "photo.variant-a1b2.jpg"
+func (r *Repo) Variants() (paths []string, err error) {
+	out, err := r.cmdOutput("git", "ls-files", "-z", "--", "*.variant-*")
+	return splitNull(out), err
+}
+
+// Splits NUL terminated output into its non-empty entries
+func splitNull(out []byte) (s []string) {
+	for _, i := range bytes.Split(out, []byte{0}) {
+		if len(i) > 0 {
+			s = append(s, string(i))
+		}
+	}
+	return
+}
@@ -0,0 +1,54 @@
+package goannex
+
+import (
+	"io"
+	"strings"
+
+	"github.com/codeskyblue/go-sh"
+)
+
+// A command for an Executor to run
+type Command struct {
+	// Working directory
+	Dir string
+	// Executable followed by its arguments
+	Argv []string
+	// Extra environment variables, as "KEY=value"
+	Env []string
+	// Destinations for the command's output. nil discards it.
+	Stdout io.Writer
+	Stderr io.Writer
+	// Echo the command before running it
+	Show bool
+}
+
+// Runs commands on behalf of a Runner. Run must not return until the command
+// has exited and all of its output has been written.
+type Executor interface {
+	Run(c *Command) error
+}
+
+// Runs commands as local processes. This is the Executor used when a Runner
+// doesn't specify one.
+type ShellExecutor struct{}
+
+func (ShellExecutor) Run(c *Command) error {
+	s := sh.NewSession()
+	for _, i := range c.Env {
+		kv := strings.SplitN(i, "=", 2)
+		if len(kv) == 2 {
+			s.SetEnv(kv[0], kv[1])
+		}
+	}
+	s.ShowCMD = c.Show
+	s.Stdout = c.Stdout
+	s.Stderr = c.Stderr
+	a := make([]interface{}, 0, len(c.Argv))
+	for _, i := range c.Argv[1:] {
+		a = append(a, i)
+	}
+	if c.Dir != "" {
+		a = append(a, sh.Dir(c.Dir))
+	}
+	return s.Command(c.Argv[0], a...).Run()
+}
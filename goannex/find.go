@@ -0,0 +1,48 @@
+package goannex
+
+import (
+	"bytes"
+	"encoding/json"
+)
+
+// An annexed file, as listed by git annex find
+type AnnexedFile struct {
+	File string `json:"file"`
+	Key  string `json:"key"`
+	// Size of the file's content, or 0 if unknown
+	Size ByteSize `json:"bytesize"`
+	// Whether git-annex knows the size, which it doesn't for some keys, such
+	// as those of files added from URLs
+	SizeKnown bool `json:"-"`
+}
+
+// Lists the annexed files whose content is not present in this repository.
+// If o.Auto is set, only files that preferred content settings want here are
+// listed.
+func (r *Repo) Missing(o *TransferOptions) (files []AnnexedFile, err error) {
+	args := []string{"find", "--json", "--not", "--in=here"}
+	if o != nil && o.Auto {
+		args = append(args, "--want-get")
+	}
+	if o != nil {
+		args = append(args, o.Paths...)
+	}
+	out, err := r.cmdOutput("git-annex", stringsToArgs(args)...)
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range bytes.Split(out, []byte("\n")) {
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+		var f AnnexedFile
+		if err = json.Unmarshal(line, &f); err != nil {
+			return nil, err
+		}
+		if f.SizeKnown = f.Size != UnknownSize; !f.SizeKnown {
+			f.Size = 0
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
@@ -1,40 +1,33 @@
 package goannex
 
 import (
-	"bufio"
+	"bytes"
 	"errors"
-	"io"
 	"os"
 
 	"github.com/codeskyblue/go-sh"
 )
 
-func init() {
-	_, err := sh.Command("git", "version").Output()
-	if err != nil {
-		panic(err)
-	}
-	_, err = sh.Command("git-annex", "version", "--raw").Output()
-	if err != nil {
-		panic(err)
-	}
-}
-
 type Repo struct {
-	Path string
-	p    sh.Dir
+	Path   string
+	p      sh.Dir
+	runner *Runner
 }
 
-func newRepo(path string) (r *Repo) {
-	return &Repo{Path: path, p: sh.Dir(path)}
+func newRepo(runner *Runner, path string) (r *Repo) {
+	return &Repo{Path: path, p: sh.Dir(path), runner: runner}
 }
 
 // Creates a new git-annex repository in the specified path
 func CreateRepo(path string) (r *Repo, err error) {
+	return createRepo(DefaultRunner, path)
+}
+
+func createRepo(runner *Runner, path string) (r *Repo, err error) {
 	if s, _ := os.Stat(path + "/.git"); s != nil && s.IsDir() {
 		return nil, errors.New("Path already contains a git repo")
 	}
-	r = newRepo(path)
+	r = newRepo(runner, path)
 	err = r.cmdNoPanic("git", "init")
 	if err != nil {
 		return nil, err
@@ -47,10 +40,14 @@ func CreateRepo(path string) (r *Repo, err error) {
 }
 
 func OpenRepo(path string) (r *Repo, err error) {
+	return openRepo(DefaultRunner, path)
+}
+
+func openRepo(runner *Runner, path string) (r *Repo, err error) {
 	if s, _ := os.Stat(path + "/.git"); s == nil || !s.IsDir() {
 		return nil, errors.New("Path is not a git repo")
 	}
-	r = newRepo(path)
+	r = newRepo(runner, path)
 	return r, nil
 }
 
@@ -94,41 +91,50 @@ func (r *Repo) FastFsck() (err error) {
 	return
 }
 
-func (r *Repo) Remotes() <-chan string {
-	c := make(chan string)
-	read, write := io.Pipe()
-	s := sh.NewSession()
-	s.Stdout = write
-	go s.Command("git", "remote", r.p).Run()
-	go func() {
-		defer close(c)
-		scanner := bufio.NewScanner(read)
-		for scanner.Scan() {
-			remote := scanner.Text()
-			c <- remote
-		}
-	}()
-	return c
+// Runs an arbitrary git command in the repository
+func (r *Repo) Git(args ...string) (err error) {
+	err = r.cmdNoPanic("git", stringsToArgs(args)...)
+	return
 }
 
-func (r *Repo) RemoveRemote(name string) (err error) {
-	err = r.cmdNoPanic("git", "remote", "rm", name)
+// Runs an arbitrary git-annex command in the repository
+func (r *Repo) Annex(args ...string) (err error) {
+	err = r.cmdNoPanic("git-annex", stringsToArgs(args)...)
 	return
 }
 
-func (r *Repo) AddRemote(name string, location string) (err error) {
-	err = r.cmdNoPanic("git", "remote", "add", name, location)
+func stringsToArgs(s []string) (a []interface{}) {
+	for _, i := range s {
+		a = append(a, i)
+	}
 	return
 }
 
 func (r *Repo) cmd(name string, a ...interface{}) {
-	a = append(a, r.p)
-	s := sh.NewSession()
-	s.ShowCMD = true
-	out, err := s.Command(name, a...).CombinedOutput()
+	out := &bytes.Buffer{}
+	err := r.runner.run(&command{dir: r.Path, name: name, args: stringArgs(a), stdout: out, stderr: out, show: true})
 	if err != nil {
-		panic(errors.New(err.Error() + "\npwd: " + string(r.p) + "\nCommand output:\n" + string(out)))
+		panic(r.commandError(err, out.String()))
+	}
+}
+
+// Runs a command in the repository and returns its standard output
+func (r *Repo) cmdOutput(name string, a ...interface{}) (out []byte, err error) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	err = r.runner.run(&command{dir: r.Path, name: name, args: stringArgs(a), stdout: stdout, stderr: stderr})
+	return stdout.Bytes(), r.commandError(err, stderr.String())
+}
+
+// Adds the working directory and command output to a failed command's error
+func (r *Repo) commandError(err error, output string) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*MissingError); ok {
+		return err
 	}
+	return errors.New(err.Error() + "\npwd: " + string(r.p) + "\nCommand output:\n" + output)
 }
 
 func (r *Repo) cmdNoPanic(name string, a ...interface{}) (err error) {
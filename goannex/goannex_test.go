@@ -59,16 +59,39 @@ func TestRemote(t *testing.T) {
 	chkerr(r2.Sync())
 	chkerr(r.Get("from-2"))
 	chkerr(r2.Get("from-1"))
-	for i := range r2.Remotes() {
-		chkerr(r2.RemoveRemote(i))
+	remotes, err := r.ListRemotes()
+	chkerr(err)
+	if len(remotes) != 1 || remotes[0].Name != "goannex-test2" || remotes[0].FetchURL != td2 {
+		t.Error("unexpected remotes", remotes)
+	}
+	if remotes[0].AnnexUUID == "" {
+		t.Error("remote annex-uuid not recorded after sync")
+	}
+	chkerr(r.SetRemoteCost("goannex-test2", 150))
+	chkerr(r.SetRemoteURL("goannex-test2", td2+"/"))
+	remotes, err = r.ListRemotes()
+	chkerr(err)
+	if remotes[0].Cost != 150 || remotes[0].FetchURL != td2+"/" {
+		t.Error("remote settings not applied", remotes[0])
 	}
-	for i := range r.Remotes() {
-		chkerr(r.RemoveRemote(i))
+	remotes, err = r2.ListRemotes()
+	chkerr(err)
+	for _, i := range remotes {
+		chkerr(r2.RemoveRemote(i.Name))
 	}
-	for _ = range r2.Remotes() {
+	remotes, err = r.ListRemotes()
+	chkerr(err)
+	for _, i := range remotes {
+		chkerr(r.RemoveRemote(i.Name))
+	}
+	remotes, err = r2.ListRemotes()
+	chkerr(err)
+	if len(remotes) != 0 {
 		t.FailNow()
 	}
-	for _ = range r.Remotes() {
+	remotes, err = r.ListRemotes()
+	chkerr(err)
+	if len(remotes) != 0 {
 		t.FailNow()
 	}
 	if cleanup {
@@ -77,6 +100,215 @@ func TestRemote(t *testing.T) {
 	}
 }
 
+func TestGetProgress(t *testing.T) {
+	chkerr := func(e error) {
+		if e != nil {
+			t.Error(e)
+			t.FailNow()
+		}
+	}
+	td3, err := ioutil.TempDir("", "goannex")
+	chkerr(err)
+	r3, err := goannex.CreateRepo(td3)
+	chkerr(err)
+	chkerr(ioutil.WriteFile(td3+"/from-3", []byte("this is from repo 3"), 0644))
+	chkerr(r3.Add(td3 + "/from-3"))
+	chkerr(r3.Commit("goannex test repo3"))
+	chkerr(r.AddRemote("goannex-test3", td3))
+	chkerr(r.Sync())
+	tr := r.GetProgress(&goannex.TransferOptions{Paths: []string{"from-3"}})
+	done := 0
+	for p := range tr.Events {
+		if p.Done {
+			if !p.Success || p.File != "from-3" {
+				t.Error("unexpected completion event", p)
+			}
+			done++
+		}
+	}
+	chkerr(tr.Wait())
+	if done != 1 {
+		t.Error("expected 1 completed file, got", done)
+	}
+	chkerr(r.RemoveRemote("goannex-test3"))
+	if cleanup {
+		chkerr(sh.Command("chmod", "-R", "0700", td3).Run())
+		chkerr(os.RemoveAll(td3))
+	}
+}
+
+func TestInfo(t *testing.T) {
+	i, err := r.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	here := i.Here()
+	if here == nil {
+		t.Fatal("info did not report the current repository")
+	}
+	if here.UUID == "" {
+		t.Error("current repository has no UUID")
+	}
+	if here.Trust != goannex.Semitrusted {
+		t.Error("expected new repository to be semitrusted, got", here.Trust)
+	}
+}
+
+func TestWhereis(t *testing.T) {
+	tf := td + "/whereis"
+	if err := ioutil.WriteFile(tf, []byte("where is this"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Add(tf); err != nil {
+		t.Fatal(err)
+	}
+	i, err := r.Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e, err := r.Whereis("whereis")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(e) != 1 {
+		t.Fatal("expected 1 whereis entry, got", len(e))
+	}
+	if e[0].File != "whereis" || e[0].Key == "" {
+		t.Error("unexpected whereis entry", e[0])
+	}
+	if !e[0].In(i.Here().UUID) {
+		t.Error("content not reported present in current repository")
+	}
+}
+
+func TestPreferredContent(t *testing.T) {
+	chkerr := func(e error) {
+		if e != nil {
+			t.Error(e)
+			t.FailNow()
+		}
+	}
+	chkerr(r.SetGroup(goannex.Here, "backup"))
+	chkerr(r.SetWanted(goannex.Here, "standard"))
+	g, err := r.Groups(goannex.Here)
+	chkerr(err)
+	if len(g) != 1 || g[0] != "backup" {
+		t.Error("expected group backup, got", g)
+	}
+	w, err := r.Wanted(goannex.Here)
+	chkerr(err)
+	if w != "standard" {
+		t.Error("expected wanted expression standard, got", w)
+	}
+	chkerr(r.Ungroup(goannex.Here, "backup"))
+	chkerr(r.SetNumCopies(2))
+	n, err := r.NumCopies()
+	chkerr(err)
+	if n != 2 {
+		t.Error("expected numcopies 2, got", n)
+	}
+	chkerr(r.SetNumCopies(1))
+}
+
+func TestTrust(t *testing.T) {
+	chkerr := func(e error) {
+		if e != nil {
+			t.Error(e)
+			t.FailNow()
+		}
+	}
+	chkerr(r.Untrust(goannex.Here))
+	i, err := r.Info()
+	chkerr(err)
+	if i.Here().Trust != goannex.Untrusted {
+		t.Error("expected untrusted, got", i.Here().Trust)
+	}
+	chkerr(r.SetTrust(goannex.Here, goannex.Semitrusted))
+	i, err = r.Info()
+	chkerr(err)
+	if i.Here().Trust != goannex.Semitrusted {
+		t.Error("expected semitrusted, got", i.Here().Trust)
+	}
+}
+
+func TestVersion(t *testing.T) {
+	for _, i := range []struct {
+		in    string
+		parts []int
+	}{
+		{"2.39.2", []int{2, 39, 2}},
+		{"2.39.2.windows.1", []int{2, 39, 2}},
+		{"10.20230126-g1234abc", []int{10, 20230126}},
+		{"8.20200226\n", []int{8, 20200226}},
+	} {
+		v, err := goannex.ParseVersion(i.in)
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if len(v.Parts) != len(i.parts) {
+			t.Error("parsing", i.in, "expected", i.parts, "got", v.Parts)
+			continue
+		}
+		for j := range i.parts {
+			if v.Parts[j] != i.parts[j] {
+				t.Error("parsing", i.in, "expected", i.parts, "got", v.Parts)
+				break
+			}
+		}
+	}
+	if _, err := goannex.ParseVersion("unknown"); err == nil {
+		t.Error("expected error parsing invalid version")
+	}
+	a, _ := goannex.ParseVersion("8.20200226")
+	b, _ := goannex.ParseVersion("10.20230126")
+	if a.AtLeast(b) || !b.AtLeast(a) || !a.AtLeast(a) {
+		t.Error("incorrect version ordering")
+	}
+	short, _ := goannex.ParseVersion("2.0")
+	c, _ := goannex.ParseVersion("2.0.0.1")
+	d, _ := goannex.ParseVersion("2.0.0")
+	if short.AtLeast(c) || !short.AtLeast(d) || !c.AtLeast(short) {
+		t.Error("incorrect ordering of versions with missing parts")
+	}
+	if _, err := goannex.AnnexVersion(); err != nil {
+		t.Error(err)
+	}
+	if err := goannex.RequireAnnex("1000"); err == nil {
+		t.Error("expected git-annex 1000 to be required")
+	} else if _, ok := err.(*goannex.VersionError); !ok {
+		t.Error("expected VersionError, got", err)
+	}
+}
+
+func TestRunner(t *testing.T) {
+	missing := &goannex.Runner{GitAnnex: "/nonexistent/git-annex"}
+	r2, err := missing.OpenRepo(td)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r2.Sync().(*goannex.MissingError); !ok {
+		t.Error("expected MissingError from missing git-annex")
+	}
+	var ran [][]string
+	logged := &goannex.Runner{
+		Env: []string{"GIT_ANNEX_TEST=1"},
+		BeforeRun: func(dir string, argv []string) {
+			ran = append(ran, argv)
+		},
+	}
+	r3, err := logged.OpenRepo(td)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r3.NumCopies(); err != nil {
+		t.Fatal(err)
+	}
+	if len(ran) != 1 || ran[0][0] != "git-annex" || ran[0][1] != "numcopies" {
+		t.Error("unexpected commands run", ran)
+	}
+}
+
 func TestMain(m *testing.M) {
 	var err error
 	chkerr := func(e error) {
@@ -0,0 +1,3 @@
+// Fake git and git-annex execution, for testing code that uses goannex
+// without git-annex installed
+package goannextest
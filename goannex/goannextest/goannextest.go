@@ -0,0 +1,148 @@
+package goannextest
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/hypoactiv/autoannex/goannex"
+)
+
+// A scripted result for commands matching a rule
+type Result struct {
+	Stdout string
+	Stderr string
+	// Returned from Run, if non-nil
+	Err error
+}
+
+type rule struct {
+	dir     string
+	prefix  []string
+	result  Result
+	handler func(c *goannex.Command) Result
+}
+
+// An Executor that records the commands it is asked to run and replies with
+// scripted results instead of running them
+type Recorder struct {
+	mu       sync.Mutex
+	rules    []rule
+	commands []goannex.Command
+}
+
+// Returns a Recorder that reports recent versions of git and git-annex, and
+// succeeds with no output for any other command
+func NewRecorder() *Recorder {
+	f := &Recorder{}
+	f.Reply("git version 2.39.2\n", "git", "version")
+	f.Reply("10.20230126\n", "git-annex", "version", "--raw")
+	return f
+}
+
+// Returns a Runner whose commands are handled by f
+func (f *Recorder) Runner() *goannex.Runner {
+	return &goannex.Runner{Executor: f}
+}
+
+// Replies with stdout to commands starting with argv, in any directory.
+// Later rules take precedence over earlier ones. The executable in argv is
+// compared by its base name, so "git-annex" matches "/opt/bin/git-annex".
+func (f *Recorder) Reply(stdout string, argv ...string) {
+	f.On("", Result{Stdout: stdout}, argv...)
+}
+
+// Fails commands starting with argv, in any directory, writing stderr
+func (f *Recorder) Fail(stderr string, argv ...string) {
+	f.On("", Result{Stderr: stderr, Err: errors.New("exit status 1")}, argv...)
+}
+
+// Replies with result to commands starting with argv run in dir, or in any
+// directory if dir is ""
+func (f *Recorder) On(dir string, result Result, argv ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = append(f.rules, rule{dir: dir, prefix: argv, result: result})
+}
+
+// Replies to commands starting with argv run in dir, or in any directory if
+// dir is "", with the result of calling handler. Handlers can script replies
+// that change as the test progresses.
+func (f *Recorder) Handle(dir string, handler func(c *goannex.Command) Result, argv ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.rules = append(f.rules, rule{dir: dir, prefix: argv, handler: handler})
+}
+
+func (f *Recorder) Run(c *goannex.Command) error {
+	f.mu.Lock()
+	recorded := *c
+	recorded.Argv = append([]string{}, c.Argv...)
+	f.commands = append(f.commands, recorded)
+	result := Result{}
+	var handler func(c *goannex.Command) Result
+	for i := len(f.rules) - 1; i >= 0; i-- {
+		if f.rules[i].matches(c) {
+			result, handler = f.rules[i].result, f.rules[i].handler
+			break
+		}
+	}
+	f.mu.Unlock()
+	if handler != nil {
+		result = handler(&recorded)
+	}
+	if c.Stdout != nil {
+		io.WriteString(c.Stdout, result.Stdout)
+	}
+	if c.Stderr != nil {
+		io.WriteString(c.Stderr, result.Stderr)
+	}
+	return result.Err
+}
+
+func (r *rule) matches(c *goannex.Command) bool {
+	if r.dir != "" && r.dir != c.Dir {
+		return false
+	}
+	if len(c.Argv) < len(r.prefix) {
+		return false
+	}
+	for i, j := range r.prefix {
+		a := c.Argv[i]
+		if i == 0 {
+			a = filepath.Base(a)
+		}
+		if a != j {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns every command run so far, in order, excluding version checks
+func (f *Recorder) Commands() (c []goannex.Command) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, i := range f.commands {
+		if len(i.Argv) > 1 && i.Argv[1] == "version" {
+			continue
+		}
+		c = append(c, i)
+	}
+	return
+}
+
+// Returns the commands run in dir, as space separated strings with the
+// executable's base name, eg "git remote add origin /path"
+func (f *Recorder) Ran(dir string) (s []string) {
+	for _, i := range f.Commands() {
+		if i.Dir != dir {
+			continue
+		}
+		argv := append([]string{filepath.Base(i.Argv[0])}, i.Argv[1:]...)
+		s = append(s, strings.Join(argv, " "))
+	}
+	return
+}
@@ -0,0 +1,176 @@
+package goannextest_test
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/hypoactiv/autoannex/goannex"
+	"github.com/hypoactiv/autoannex/goannex/goannextest"
+)
+
+func openFake(t *testing.T, f *goannextest.Recorder) *goannex.Repo {
+	td, err := ioutil.TempDir("", "goannextest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(td) })
+	if err = os.Mkdir(td+"/.git", 0755); err != nil {
+		t.Fatal(err)
+	}
+	r, err := f.Runner().OpenRepo(td)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func TestRecorder(t *testing.T) {
+	f := goannextest.NewRecorder()
+	f.Fail("no such remote", "git", "remote", "rm")
+	r := openFake(t, f)
+	if err := r.Sync(); err != nil {
+		t.Error(err)
+	}
+	if err := r.RemoveRemote("origin"); err == nil {
+		t.Error("expected scripted failure")
+	}
+	ran := f.Ran(r.Path)
+	if len(ran) != 2 || ran[0] != "git-annex sync" || ran[1] != "git remote rm origin" {
+		t.Error("unexpected commands recorded", ran)
+	}
+}
+
+func TestFakeInfo(t *testing.T) {
+	f := goannextest.NewRecorder()
+	f.Reply(`{"trusted repositories":[],`+
+		`"semitrusted repositories":[{"uuid":"00000000-0000-0000-0000-000000000001","description":"web","here":false},`+
+		`{"uuid":"8d1e4f5c-1111-2222-3333-444455556666","description":"laptop [here]","here":true}],`+
+		`"untrusted repositories":[{"uuid":"8d1e4f5c-aaaa-bbbb-cccc-ddddeeeeffff","description":"usb","here":false}],`+
+		`"local annex keys":3,"local annex size":"3072","annexed files in working tree":4,`+
+		`"size of annexed files in working tree":"4096 (but 1 keys are of unknown size)",`+
+		`"available local disk space":"1000000 (+1048576 reserved)","success":true}`,
+		"git-annex", "info")
+	i, err := openFake(t, f).Info()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(i.Repositories) != 3 {
+		t.Fatal("expected 3 repositories, got", len(i.Repositories))
+	}
+	if h := i.Here(); h == nil || h.UUID != "8d1e4f5c-1111-2222-3333-444455556666" || h.Trust != goannex.Semitrusted {
+		t.Error("unexpected current repository", h)
+	}
+	if i.Repositories[2].Trust != goannex.Untrusted {
+		t.Error("expected usb to be untrusted")
+	}
+	if i.LocalSize != 3072 || i.WorkingTreeSize != 4096 || !i.SizesIncomplete || i.AvailableSpace != 1000000 {
+		t.Error("unexpected sizes", i)
+	}
+}
+
+func TestFakeWhereis(t *testing.T) {
+	f := goannextest.NewRecorder()
+	f.On("", goannextest.Result{
+		Stdout: `{"command":"whereis","file":"a","key":"KA","whereis":[{"uuid":"u1","description":"one","here":true}],"untrusted":[],"success":true}` + "\n" +
+			`{"command":"whereis","file":"b","key":"KB","whereis":[],"untrusted":[],"success":false}` + "\n",
+		Err: os.ErrNotExist,
+	}, "git-annex", "whereis")
+	e, err := openFake(t, f).Whereis()
+	if err == nil {
+		t.Error("expected error when some files have no copies")
+	}
+	if len(e) != 2 {
+		t.Fatal("expected 2 entries, got", len(e))
+	}
+	if !e[0].In("u1") || e[1].In("u1") {
+		t.Error("unexpected locations", e)
+	}
+}
+
+func TestFakeListRemotes(t *testing.T) {
+	f := goannextest.NewRecorder()
+	f.Reply("origin\nbackup.usb\n", "git", "remote")
+	f.Reply("remote.origin.url\n/srv/annex\x00"+
+		"remote.origin.fetch\n+refs/heads/*:refs/remotes/origin/*\x00"+
+		"remote.origin.annex-uuid\n1234\x00"+
+		"remote.backup.usb.url\n/media/usb\x00"+
+		"remote.backup.usb.pushurl\n/media/usb-push\x00"+
+		"remote.backup.usb.annex-cost\n50\x00"+
+		"remote.backup.usb.annex-ignore\ntrue\x00",
+		"git", "config", "--null", "--get-regexp")
+	remotes, err := openFake(t, f).ListRemotes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remotes) != 2 {
+		t.Fatal("expected 2 remotes, got", remotes)
+	}
+	o, b := remotes[0], remotes[1]
+	if o.Name != "origin" || o.FetchURL != "/srv/annex" || o.PushURL != "/srv/annex" || o.AnnexUUID != "1234" || o.AnnexIgnore || o.Cost != 0 {
+		t.Error("unexpected origin remote", o)
+	}
+	if b.Name != "backup.usb" || b.FetchURL != "/media/usb" || b.PushURL != "/media/usb-push" || !b.AnnexIgnore || b.Cost != 50 {
+		t.Error("unexpected backup.usb remote", b)
+	}
+}
+
+func TestFakeConflicts(t *testing.T) {
+	f := goannextest.NewRecorder()
+	f.Reply("a.txt\x00dir/b.jpg\x00", "git", "diff", "--name-only", "--diff-filter=U")
+	synced := false
+	f.Handle("", func(c *goannex.Command) goannextest.Result {
+		if synced {
+			return goannextest.Result{Stdout: "old.variant-1111\x00c.variant-a1b2.txt\x00"}
+		}
+		return goannextest.Result{Stdout: "old.variant-1111\x00"}
+	}, "git", "ls-files")
+	r := openFake(t, f)
+	c, err := r.Conflicts()
+	if err != nil || len(c) != 2 || c[0] != "a.txt" || c[1] != "dir/b.jpg" {
+		t.Error("unexpected conflicts", c, err)
+	}
+	v, err := r.Variants()
+	if err != nil || len(v) != 1 {
+		t.Error("unexpected variants", v, err)
+	}
+	synced = true
+	if v, _ = r.Variants(); len(v) != 2 || v[1] != "c.variant-a1b2.txt" {
+		t.Error("unexpected variants after sync", v)
+	}
+}
+
+func TestFakeMissing(t *testing.T) {
+	f := goannextest.NewRecorder()
+	f.Reply(`{"file":"a.iso","key":"SHA256E-s4096--aa.iso","bytesize":"4096"}`+"\n"+
+		`{"file":"web.html","key":"URL--http://example.com/web.html","bytesize":"unknown"}`+"\n",
+		"git-annex", "find")
+	r := openFake(t, f)
+	files, err := r.Missing(&goannex.TransferOptions{Auto: true, Paths: []string{"iso"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 || files[0].File != "a.iso" || files[0].Size != 4096 || !files[0].SizeKnown ||
+		files[1].Size != 0 || files[1].SizeKnown {
+		t.Errorf("unexpected files %+v", files)
+	}
+	if ran := f.Ran(r.Path); ran[0] != "git-annex find --json --not --in=here --want-get iso" {
+		t.Error("unexpected command", ran[0])
+	}
+}
+
+func TestFakeAdjusted(t *testing.T) {
+	f := goannextest.NewRecorder()
+	f.Reply("adjusted/master(unlocked)\n", "git", "symbolic-ref")
+	f.Reply("true\n", "git", "config", "--bool")
+	r := openFake(t, f)
+	if b, err := r.CurrentBranch(); err != nil || b != "adjusted/master(unlocked)" {
+		t.Error("unexpected branch", b, err)
+	}
+	if a, err := r.Adjusted(); err != nil || !a {
+		t.Error("expected an adjusted branch", err)
+	}
+	if c, err := r.Crippled(); err != nil || !c {
+		t.Error("expected a crippled filesystem", err)
+	}
+}
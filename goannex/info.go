@@ -0,0 +1,218 @@
+package goannex
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// A size in bytes, as reported by git-annex. git-annex reports sizes as
+// strings, which are parsed when --bytes is in effect.
+type ByteSize int64
+
+// A size git-annex doesn't know, such as that of a file added from a URL
+const UnknownSize ByteSize = -1
+
+func (b *ByteSize) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		// Not a string, try a plain number
+		var n int64
+		if err := json.Unmarshal(data, &n); err != nil {
+			return err
+		}
+		*b = ByteSize(n)
+		return nil
+	}
+	// The size is the leading number. git-annex may follow it with eg
+	// " bytes", " (+1000 reserved)" or " (but 2 keys are of unknown size)".
+	s = strings.TrimSpace(s)
+	end := strings.IndexFunc(s, func(r rune) bool { return r < '0' || r > '9' })
+	if end < 0 {
+		end = len(s)
+	}
+	if end == 0 {
+		*b = UnknownSize
+		return nil
+	}
+	n, err := strconv.ParseInt(s[:end], 10, 64)
+	if err != nil {
+		return err
+	}
+	*b = ByteSize(n)
+	return nil
+}
+
+// Trust level of a repository, as known to git-annex
+type TrustLevel string
+
+const (
+	Trusted     TrustLevel = "trusted"
+	Semitrusted TrustLevel = "semitrusted"
+	Untrusted   TrustLevel = "untrusted"
+	DeadTrust   TrustLevel = "dead"
+)
+
+// A repository known to git-annex
+type RepoDescription struct {
+	UUID        string `json:"uuid"`
+	Description string `json:"description"`
+	Here        bool   `json:"here"`
+	Trust       TrustLevel
+}
+
+// Information about a repository, as reported by git annex info
+type RepoInfo struct {
+	// All repositories known to this one, with their trust levels
+	Repositories []RepoDescription
+	// Number and total size of annexed objects present in this repository
+	LocalKeys int64
+	LocalSize ByteSize
+	// Number and total size of annexed files in the working tree
+	WorkingTreeFiles int64
+	WorkingTreeSize  ByteSize
+	// Set if some keys are of unknown size, making LocalSize and
+	// WorkingTreeSize lower bounds
+	SizesIncomplete bool
+	// Free space on the disk holding the repository, less annex.diskreserve
+	AvailableSpace ByteSize
+}
+
+// The repository described by this RepoInfo, or nil if it is unknown
+func (i *RepoInfo) Here() *RepoDescription {
+	for j := range i.Repositories {
+		if i.Repositories[j].Here {
+			return &i.Repositories[j]
+		}
+	}
+	return nil
+}
+
+// A size reported by git annex info, which notes when some keys are of
+// unknown size
+type infoSize struct {
+	ByteSize
+	incomplete bool
+}
+
+func (s *infoSize) UnmarshalJSON(data []byte) error {
+	s.incomplete = bytes.Contains(data, []byte("unknown size"))
+	return s.ByteSize.UnmarshalJSON(data)
+}
+
+type rawRepoInfo struct {
+	Trusted          []RepoDescription `json:"trusted repositories"`
+	Semitrusted      []RepoDescription `json:"semitrusted repositories"`
+	Untrusted        []RepoDescription `json:"untrusted repositories"`
+	LocalKeys        int64             `json:"local annex keys"`
+	LocalSize        infoSize          `json:"local annex size"`
+	WorkingTreeFiles int64             `json:"annexed files in working tree"`
+	WorkingTreeSize  infoSize          `json:"size of annexed files in working tree"`
+	AvailableSpace   ByteSize          `json:"available local disk space"`
+}
+
+// Returns information about the repository via git annex info
+func (r *Repo) Info() (i *RepoInfo, err error) {
+	out, err := r.cmdOutput("git-annex", "info", "--json", "--bytes")
+	if err != nil {
+		return nil, err
+	}
+	return parseInfo(out)
+}
+
+func parseInfo(out []byte) (i *RepoInfo, err error) {
+	var raw rawRepoInfo
+	if err = json.Unmarshal(out, &raw); err != nil {
+		return nil, err
+	}
+	i = &RepoInfo{
+		LocalKeys:        raw.LocalKeys,
+		LocalSize:        raw.LocalSize.ByteSize,
+		WorkingTreeFiles: raw.WorkingTreeFiles,
+		WorkingTreeSize:  raw.WorkingTreeSize.ByteSize,
+		SizesIncomplete:  raw.LocalSize.incomplete || raw.WorkingTreeSize.incomplete,
+		AvailableSpace:   raw.AvailableSpace,
+	}
+	for _, j := range []struct {
+		t TrustLevel
+		l []RepoDescription
+	}{
+		{Trusted, raw.Trusted},
+		{Semitrusted, raw.Semitrusted},
+		{Untrusted, raw.Untrusted},
+	} {
+		for _, k := range j.l {
+			k.Trust = j.t
+			i.Repositories = append(i.Repositories, k)
+		}
+	}
+	return i, nil
+}
+
+// A repository containing a copy of a file's content
+type Location struct {
+	UUID        string   `json:"uuid"`
+	Description string   `json:"description"`
+	Here        bool     `json:"here"`
+	URLs        []string `json:"urls"`
+}
+
+// The locations of a single annexed file, as reported by git annex whereis
+type WhereisEntry struct {
+	File      string     `json:"file"`
+	Key       string     `json:"key"`
+	Whereis   []Location `json:"whereis"`
+	Untrusted []Location `json:"untrusted"`
+	Note      string     `json:"note"`
+	Success   bool       `json:"success"`
+}
+
+// Returns true if the content is present in the repository with this UUID
+func (e *WhereisEntry) In(uuid string) bool {
+	for _, l := range e.Whereis {
+		if l.UUID == uuid {
+			return true
+		}
+	}
+	for _, l := range e.Untrusted {
+		if l.UUID == uuid {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the locations of annexed files in paths, or in the whole working
+// tree if no paths are given.
+//
+// git annex whereis fails when some files have no known copies, so entries
+// may be returned along with a non-nil error.
+func (r *Repo) Whereis(paths ...string) (entries []WhereisEntry, err error) {
+	a := []interface{}{"whereis", "--json"}
+	for _, p := range paths {
+		a = append(a, p)
+	}
+	out, err := r.cmdOutput("git-annex", a...)
+	entries, perr := parseWhereis(out)
+	if err == nil {
+		err = perr
+	}
+	return entries, err
+}
+
+func parseWhereis(out []byte) (entries []WhereisEntry, err error) {
+	d := json.NewDecoder(bytes.NewReader(out))
+	for {
+		var e WhereisEntry
+		err = d.Decode(&e)
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+}
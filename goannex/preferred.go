@@ -0,0 +1,101 @@
+package goannex
+
+import (
+	"strconv"
+	"strings"
+)
+
+// The repository name git-annex uses to refer to the current repository
+const Here = "here"
+
+// git-annex's standard groups, whose preferred content is selected by
+// setting a member's wanted expression to "standard"
+var StandardGroups = []string{
+	"client",
+	"transfer",
+	"backup",
+	"incrementalbackup",
+	"smallarchive",
+	"archive",
+	"source",
+	"manual",
+	"public",
+	"unwanted",
+}
+
+// Returns the preferred content expression of repo, which may be a remote
+// name, a UUID, a description or Here
+func (r *Repo) Wanted(repo string) (expr string, err error) {
+	return r.cmdString("git-annex", "wanted", repo)
+}
+
+// Sets the preferred content expression of repo
+func (r *Repo) SetWanted(repo string, expr string) (err error) {
+	err = r.cmdNoPanic("git-annex", "wanted", repo, expr)
+	return
+}
+
+// Returns the required content expression of repo
+func (r *Repo) Required(repo string) (expr string, err error) {
+	return r.cmdString("git-annex", "required", repo)
+}
+
+// Sets the required content expression of repo
+func (r *Repo) SetRequired(repo string, expr string) (err error) {
+	err = r.cmdNoPanic("git-annex", "required", repo, expr)
+	return
+}
+
+// Returns the groups repo belongs to
+func (r *Repo) Groups(repo string) (groups []string, err error) {
+	out, err := r.cmdString("git-annex", "group", repo)
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(out), nil
+}
+
+// Adds repo to group
+func (r *Repo) SetGroup(repo string, group string) (err error) {
+	err = r.cmdNoPanic("git-annex", "group", repo, group)
+	return
+}
+
+// Removes repo from group
+func (r *Repo) Ungroup(repo string, group string) (err error) {
+	err = r.cmdNoPanic("git-annex", "ungroup", repo, group)
+	return
+}
+
+// Returns the preferred content expression shared by members of group
+func (r *Repo) GroupWanted(group string) (expr string, err error) {
+	return r.cmdString("git-annex", "groupwanted", group)
+}
+
+// Sets the preferred content expression shared by members of group
+func (r *Repo) SetGroupWanted(group string, expr string) (err error) {
+	err = r.cmdNoPanic("git-annex", "groupwanted", group, expr)
+	return
+}
+
+// Returns the desired number of copies of each file
+func (r *Repo) NumCopies() (n int, err error) {
+	out, err := r.cmdString("git-annex", "numcopies")
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(out)
+}
+
+// Sets the desired number of copies of each file
+func (r *Repo) SetNumCopies(n int) (err error) {
+	err = r.cmdNoPanic("git-annex", "numcopies", strconv.Itoa(n))
+	return
+}
+
+// Runs a command and returns its standard output with surrounding
+// whitespace removed
+func (r *Repo) cmdString(name string, a ...interface{}) (string, error) {
+	out, err := r.cmdOutput(name, a...)
+	return strings.TrimSpace(string(out)), err
+}
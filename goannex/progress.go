@@ -0,0 +1,135 @@
+package goannex
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+)
+
+// A progress report for a single file, decoded from git-annex's
+// --json-progress output
+type Progress struct {
+	Command string
+	File    string
+	Key     string
+	Note    string
+	// Bytes transferred so far, and the total size of the file if known
+	BytesDone int64
+	TotalSize int64
+	// Set once the file has finished transferring, successfully or not
+	Done    bool
+	Success bool
+	Errors  []string
+}
+
+// A running git-annex transfer command
+type Transfer struct {
+	// Progress events, closed when the command exits. Must be drained by
+	// the caller.
+	Events <-chan Progress
+	done   chan struct{}
+	err    error
+}
+
+// Waits for the transfer to finish and returns its error, if any
+func (t *Transfer) Wait() error {
+	<-t.done
+	return t.err
+}
+
+type rawAction struct {
+	Command string `json:"command"`
+	Key     string `json:"key"`
+	File    string `json:"file"`
+	Note    string `json:"note"`
+}
+
+type rawProgress struct {
+	rawAction
+	Action        *rawAction `json:"action"`
+	ByteProgress  int64      `json:"byte-progress"`
+	TotalSize     int64      `json:"total-size"`
+	Success       *bool      `json:"success"`
+	ErrorMessages []string   `json:"error-messages"`
+}
+
+func (p *rawProgress) progress() (e Progress) {
+	a := p.rawAction
+	if p.Action != nil {
+		a = *p.Action
+	}
+	e = Progress{
+		Command:   a.Command,
+		File:      a.File,
+		Key:       a.Key,
+		Note:      a.Note,
+		BytesDone: p.ByteProgress,
+		TotalSize: p.TotalSize,
+		Errors:    p.ErrorMessages,
+	}
+	if p.Success != nil {
+		e.Done = true
+		e.Success = *p.Success
+	}
+	return
+}
+
+// Runs git-annex get --auto, reporting progress as files are transferred
+func (r *Repo) GetAutoProgress() *Transfer {
+	return r.GetProgress(&TransferOptions{Auto: true})
+}
+
+// Runs git-annex get, reporting progress as files are transferred
+func (r *Repo) GetProgress(o *TransferOptions) *Transfer {
+	return r.transfer("get", o.args()...)
+}
+
+// Runs git-annex copy --to remote, reporting progress as files are
+// transferred
+func (r *Repo) CopyToProgress(remote string, o *TransferOptions) *Transfer {
+	return r.transfer("copy", append([]string{"--to", remote}, o.args()...)...)
+}
+
+// Runs git-annex move --to remote, reporting progress as files are
+// transferred
+func (r *Repo) MoveToProgress(remote string, o *TransferOptions) *Transfer {
+	return r.transfer("move", append([]string{"--to", remote}, o.args()...)...)
+}
+
+func (r *Repo) transfer(subcommand string, args ...string) *Transfer {
+	events := make(chan Progress)
+	t := &Transfer{Events: events, done: make(chan struct{})}
+	read, write := io.Pipe()
+	stderr := &bytes.Buffer{}
+	go func() {
+		err := r.runner.run(&command{
+			dir:    r.Path,
+			name:   "git-annex",
+			args:   append([]string{subcommand, "--json", "--json-progress"}, args...),
+			stdout: write,
+			stderr: stderr,
+		})
+		write.CloseWithError(r.commandError(err, stderr.String()))
+	}()
+	go func() {
+		defer close(t.done)
+		defer close(events)
+		d := json.NewDecoder(read)
+		for {
+			var p rawProgress
+			err := d.Decode(&p)
+			if err == io.EOF {
+				return
+			}
+			if err != nil {
+				t.err = err
+				// Let the command finish
+				io.Copy(ioutil.Discard, read)
+				return
+			}
+			events <- p.progress()
+		}
+	}()
+	return t
+}
@@ -0,0 +1,148 @@
+package goannex
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+// git-annex's default costs for accessing remotes of various kinds
+const (
+	CheapRemoteCost         = 100
+	ExpensiveRemoteCost     = 200
+	VeryExpensiveRemoteCost = 1000
+)
+
+// A git remote, and the git-annex settings attached to it
+type Remote struct {
+	Name string
+	// URLs used to fetch from and push to the remote. PushURL is the same as
+	// FetchURL unless a separate push URL is configured.
+	FetchURL string
+	PushURL  string
+	// UUID of the git-annex repository the remote points at, once known
+	AnnexUUID string
+	// Set if git-annex should not use the remote
+	AnnexIgnore bool
+	// git-annex's cost of accessing the remote, or 0 if not configured
+	Cost int
+	// Every remote.<name>.* setting, keyed by the part after the name
+	Config map[string]string
+}
+
+// Returns the repository's remotes, in the order git lists them
+func (r *Repo) ListRemotes() (remotes []Remote, err error) {
+	names, err := r.cmdString("git", "remote")
+	if err != nil {
+		return nil, err
+	}
+	index := make(map[string]int)
+	for _, name := range strings.Fields(names) {
+		index[name] = len(remotes)
+		remotes = append(remotes, Remote{Name: name, Config: make(map[string]string)})
+	}
+	if len(remotes) == 0 {
+		return remotes, nil
+	}
+	out, err := r.cmdOutput("git", "config", "--null", "--get-regexp", `^remote\.`)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range parseConfigList(out) {
+		// Remote names may contain dots, so match against the known names
+		// rather than splitting the key
+		name, key := remoteKey(i[0], index)
+		if name == "" {
+			continue
+		}
+		remotes[index[name]].Config[key] = i[1]
+	}
+	for i := range remotes {
+		remotes[i].fromConfig()
+	}
+	return remotes, nil
+}
+
+func (m *Remote) fromConfig() {
+	c := m.Config
+	m.FetchURL = c["url"]
+	m.PushURL = c["pushurl"]
+	if m.PushURL == "" {
+		m.PushURL = m.FetchURL
+	}
+	m.AnnexUUID = c["annex-uuid"]
+	m.AnnexIgnore = c["annex-ignore"] == "true"
+	m.Cost, _ = strconv.Atoi(c["annex-cost"])
+}
+
+// Splits a "remote.<name>.<key>" config key into name and key, if name is a
+// known remote
+func remoteKey(k string, names map[string]int) (name, key string) {
+	k = strings.TrimPrefix(k, "remote.")
+	dot := strings.LastIndex(k, ".")
+	if dot < 0 {
+		return "", ""
+	}
+	if _, ok := names[k[:dot]]; !ok {
+		return "", ""
+	}
+	return k[:dot], k[dot+1:]
+}
+
+// Parses the output of git config --null --get-regexp into key, value pairs
+func parseConfigList(out []byte) (kv [][2]string) {
+	s := bufio.NewScanner(bytes.NewReader(out))
+	s.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.IndexByte(data, 0); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+	for s.Scan() {
+		// Each entry is the key, a newline, then the value
+		e := strings.SplitN(s.Text(), "\n", 2)
+		if len(e) == 1 {
+			e = append(e, "")
+		}
+		kv = append(kv, [2]string{e[0], e[1]})
+	}
+	return
+}
+
+func (r *Repo) RemoveRemote(name string) (err error) {
+	err = r.cmdNoPanic("git", "remote", "rm", name)
+	return
+}
+
+func (r *Repo) AddRemote(name string, location string) (err error) {
+	err = r.cmdNoPanic("git", "remote", "add", name, location)
+	return
+}
+
+// Sets the URL of the named remote
+func (r *Repo) SetRemoteURL(name string, url string) (err error) {
+	err = r.cmdNoPanic("git", "remote", "set-url", name, url)
+	return
+}
+
+// Sets git-annex's cost of accessing the named remote. Lower cost remotes
+// are preferred when transferring content.
+func (r *Repo) SetRemoteCost(name string, cost int) (err error) {
+	return r.SetRemoteConfig(name, "annex-cost", strconv.Itoa(cost))
+}
+
+// Stops git-annex from changing the named remote, so that sync fetches from
+// it but doesn't push to it, and content is only got from it
+func (r *Repo) SetRemoteReadOnly(name string) (err error) {
+	return r.SetRemoteConfig(name, "annex-readonly", "true")
+}
+
+// Sets remote.<name>.<key> in the repository's git config
+func (r *Repo) SetRemoteConfig(name string, key string, value string) (err error) {
+	err = r.cmdNoPanic("git", "config", "remote."+name+"."+key, value)
+	return
+}
@@ -0,0 +1,120 @@
+package goannex
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// Runs git and git-annex on behalf of Repos. The zero value runs whichever
+// git and git-annex are first in $PATH, with the inherited environment.
+type Runner struct {
+	// Paths of the git and git-annex executables. Empty means look them up
+	// in $PATH.
+	Git      string
+	GitAnnex string
+	// Extra environment variables for every command, as "KEY=value", eg
+	// "GIT_SSH_COMMAND=ssh -i key"
+	Env []string
+	// Runs the commands. nil means ShellExecutor.
+	Executor Executor
+	// If non-nil, called before and after each command is run
+	BeforeRun func(dir string, argv []string)
+	AfterRun  func(dir string, argv []string, err error, elapsed time.Duration)
+
+	detectOnce   sync.Once
+	detectErr    error
+	gitVersion   Version
+	annexVersion Version
+}
+
+// The Runner used by OpenRepo and CreateRepo
+var DefaultRunner = &Runner{}
+
+// Creates a new git-annex repository in the specified path, using this
+// Runner's git and git-annex
+func (c *Runner) CreateRepo(path string) (r *Repo, err error) {
+	return createRepo(c, path)
+}
+
+// Opens the git-annex repository in the specified path, using this Runner's
+// git and git-annex
+func (c *Runner) OpenRepo(path string) (r *Repo, err error) {
+	return openRepo(c, path)
+}
+
+// A single command to be run
+type command struct {
+	dir    string
+	name   string
+	args   []string
+	stdout io.Writer
+	stderr io.Writer
+	// Echo the command before running it
+	show bool
+}
+
+// Returns the executable to run for name, which is "git" or "git-annex"
+func (c *Runner) executable(name string) string {
+	switch {
+	case name == "git" && c.Git != "":
+		return c.Git
+	case name == "git-annex" && c.GitAnnex != "":
+		return c.GitAnnex
+	}
+	return name
+}
+
+func (c *Runner) executor() Executor {
+	if c.Executor == nil {
+		return ShellExecutor{}
+	}
+	return c.Executor
+}
+
+// Runs cmd, after making sure git and git-annex are available
+func (c *Runner) run(cmd *command) (err error) {
+	if err = c.detect(); err != nil {
+		return err
+	}
+	argv := append([]string{c.executable(cmd.name)}, cmd.args...)
+	if c.BeforeRun != nil {
+		c.BeforeRun(cmd.dir, argv)
+	}
+	start := time.Now()
+	err = c.executor().Run(&Command{
+		Dir:    cmd.dir,
+		Argv:   argv,
+		Env:    c.Env,
+		Stdout: cmd.stdout,
+		Stderr: cmd.stderr,
+		Show:   cmd.show,
+	})
+	if c.AfterRun != nil {
+		c.AfterRun(cmd.dir, argv, err, time.Since(start))
+	}
+	return
+}
+
+// Runs a command without checking for git and git-annex, returning its
+// standard output
+func (c *Runner) output(name string, args ...string) ([]byte, error) {
+	out := &bytes.Buffer{}
+	err := c.executor().Run(&Command{
+		Argv:   append([]string{c.executable(name)}, args...),
+		Env:    c.Env,
+		Stdout: out,
+	})
+	return out.Bytes(), err
+}
+
+// Converts command arguments to strings
+func stringArgs(a []interface{}) (s []string) {
+	s = make([]string, len(a))
+	for i, j := range a {
+		s[i] = fmt.Sprint(j)
+	}
+	return
+}
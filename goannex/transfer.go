@@ -0,0 +1,62 @@
+package goannex
+
+import "strconv"
+
+// Options for commands that transfer or drop annexed content. A nil
+// *TransferOptions operates on the whole working tree with git-annex's
+// defaults.
+type TransferOptions struct {
+	// Only operate on content that preferred content settings say should be
+	// transferred or dropped
+	Auto bool
+	// Overrides the numcopies setting when non-zero
+	NumCopies int
+	// Paths to operate on. Empty means the whole working tree.
+	Paths []string
+	// Number of transfers to run in parallel when non-zero
+	Jobs int
+}
+
+func (o *TransferOptions) args() (a []string) {
+	if o == nil {
+		return nil
+	}
+	if o.Auto {
+		a = append(a, "--auto")
+	}
+	if o.NumCopies > 0 {
+		a = append(a, "--numcopies="+strconv.Itoa(o.NumCopies))
+	}
+	if o.Jobs > 0 {
+		a = append(a, "--jobs="+strconv.Itoa(o.Jobs))
+	}
+	return append(a, o.Paths...)
+}
+
+// Copies content to the named remote
+func (r *Repo) CopyTo(remote string, o *TransferOptions) (err error) {
+	err = r.cmdNoPanic("git-annex", transferArgs("copy", "--to", remote, o)...)
+	return
+}
+
+// Moves content to the named remote, dropping it from this repository
+func (r *Repo) MoveTo(remote string, o *TransferOptions) (err error) {
+	err = r.cmdNoPanic("git-annex", transferArgs("move", "--to", remote, o)...)
+	return
+}
+
+// Drops content from this repository
+func (r *Repo) Drop(o *TransferOptions) (err error) {
+	err = r.cmdNoPanic("git-annex", append([]interface{}{"drop"}, stringsToArgs(o.args())...)...)
+	return
+}
+
+// Drops content from the named remote
+func (r *Repo) DropFrom(remote string, o *TransferOptions) (err error) {
+	err = r.cmdNoPanic("git-annex", transferArgs("drop", "--from", remote, o)...)
+	return
+}
+
+func transferArgs(command, dirFlag, remote string, o *TransferOptions) []interface{} {
+	return append([]interface{}{command, dirFlag, remote}, stringsToArgs(o.args())...)
+}
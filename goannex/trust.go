@@ -0,0 +1,42 @@
+package goannex
+
+import "errors"
+
+// Marks repo as trusted, so its copies are counted without checking
+func (r *Repo) Trust(repo string) (err error) {
+	err = r.cmdNoPanic("git-annex", "trust", "--force", repo)
+	return
+}
+
+// Returns repo to the default trust level
+func (r *Repo) Semitrust(repo string) (err error) {
+	err = r.cmdNoPanic("git-annex", "semitrust", repo)
+	return
+}
+
+// Marks repo as untrusted, so its copies are never relied upon
+func (r *Repo) Untrust(repo string) (err error) {
+	err = r.cmdNoPanic("git-annex", "untrust", repo)
+	return
+}
+
+// Marks repo as dead, so it is assumed to be lost along with its copies
+func (r *Repo) Dead(repo string) (err error) {
+	err = r.cmdNoPanic("git-annex", "dead", repo)
+	return
+}
+
+// Sets the trust level of repo
+func (r *Repo) SetTrust(repo string, level TrustLevel) (err error) {
+	switch level {
+	case Trusted:
+		return r.Trust(repo)
+	case Semitrusted:
+		return r.Semitrust(repo)
+	case Untrusted:
+		return r.Untrust(repo)
+	case DeadTrust:
+		return r.Dead(repo)
+	}
+	return errors.New("Unknown trust level " + string(level))
+}
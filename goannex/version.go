@@ -0,0 +1,172 @@
+package goannex
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// Returned by Repo operations when git or git-annex can't be run
+type MissingError struct {
+	Program string
+	Err     error
+}
+
+func (e *MissingError) Error() string {
+	return e.Program + " is not available: " + e.Err.Error()
+}
+
+// Returned by Require* when an installed program is too old
+type VersionError struct {
+	Program string
+	Have    Version
+	Need    Version
+}
+
+func (e *VersionError) Error() string {
+	return e.Program + " " + e.Need.String() + " or newer is required, found " + e.Have.String()
+}
+
+// A program version, such as "2.39.2" for git or "10.20230126" for
+// git-annex
+type Version struct {
+	Raw   string
+	Parts []int
+}
+
+// Parses the leading dotted numeric part of s. Trailing suffixes like
+// "-g1234abc" or ".windows.1" are kept in Raw but otherwise ignored.
+func ParseVersion(s string) (v Version, err error) {
+	v.Raw = strings.TrimSpace(s)
+	for _, i := range strings.Split(v.Raw, ".") {
+		end := 0
+		for end < len(i) && i[end] >= '0' && i[end] <= '9' {
+			end++
+		}
+		if end == 0 {
+			break
+		}
+		n, _ := strconv.Atoi(i[:end])
+		v.Parts = append(v.Parts, n)
+		if end < len(i) {
+			break
+		}
+	}
+	if len(v.Parts) == 0 {
+		return v, errors.New("Unable to parse version " + strconv.Quote(s))
+	}
+	return v, nil
+}
+
+func (v Version) String() string {
+	if v.Raw != "" {
+		return v.Raw
+	}
+	p := make([]string, len(v.Parts))
+	for i, j := range v.Parts {
+		p[i] = strconv.Itoa(j)
+	}
+	return strings.Join(p, ".")
+}
+
+// Returns true if v is the same as or newer than min
+func (v Version) AtLeast(min Version) bool {
+	for i, j := range min.Parts {
+		// Missing parts count as 0, so 2.0 is 2.0.0
+		have := 0
+		if i < len(v.Parts) {
+			have = v.Parts[i]
+		}
+		if have != j {
+			return have > j
+		}
+	}
+	return true
+}
+
+// Finds and parses the versions of git and git-annex, once
+func (c *Runner) detect() error {
+	c.detectOnce.Do(func() {
+		out, err := c.output("git", "version")
+		if err != nil {
+			c.detectErr = &MissingError{Program: "git", Err: err}
+			return
+		}
+		// "git version 2.39.2"
+		f := strings.Fields(string(out))
+		if len(f) < 3 {
+			c.detectErr = &MissingError{Program: "git", Err: errors.New("unexpected version output " + strconv.Quote(string(out)))}
+			return
+		}
+		if c.gitVersion, err = ParseVersion(f[2]); err != nil {
+			c.detectErr = &MissingError{Program: "git", Err: err}
+			return
+		}
+		out, err = c.output("git-annex", "version", "--raw")
+		if err != nil {
+			c.detectErr = &MissingError{Program: "git-annex", Err: err}
+			return
+		}
+		if c.annexVersion, err = ParseVersion(string(out)); err != nil {
+			c.detectErr = &MissingError{Program: "git-annex", Err: err}
+		}
+	})
+	return c.detectErr
+}
+
+// Returns the version of git run by this Runner
+func (c *Runner) GitVersion() (Version, error) {
+	err := c.detect()
+	return c.gitVersion, err
+}
+
+// Returns the version of git-annex run by this Runner
+func (c *Runner) AnnexVersion() (Version, error) {
+	err := c.detect()
+	return c.annexVersion, err
+}
+
+// Returns an error if this Runner's git-annex is missing or older than min
+func (c *Runner) RequireAnnex(min string) error {
+	return require("git-annex", c.AnnexVersion, min)
+}
+
+// Returns an error if this Runner's git is missing or older than min
+func (c *Runner) RequireGit(min string) error {
+	return require("git", c.GitVersion, min)
+}
+
+// Returns the installed version of git
+func GitVersion() (Version, error) {
+	return DefaultRunner.GitVersion()
+}
+
+// Returns the installed version of git-annex
+func AnnexVersion() (Version, error) {
+	return DefaultRunner.AnnexVersion()
+}
+
+// Returns an error if git-annex is missing or older than min
+func RequireAnnex(min string) error {
+	return DefaultRunner.RequireAnnex(min)
+}
+
+// Returns an error if git is missing or older than min
+func RequireGit(min string) error {
+	return DefaultRunner.RequireGit(min)
+}
+
+func require(program string, installed func() (Version, error), min string) error {
+	have, err := installed()
+	if err != nil {
+		return err
+	}
+	need, err := ParseVersion(min)
+	if err != nil {
+		return err
+	}
+	if !have.AtLeast(need) {
+		return &VersionError{Program: program, Have: have, Need: need}
+	}
+	return nil
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Step of a member's run, as recorded in the history
+type stepRecord struct {
+	Step    string        `json:"step"`
+	Elapsed time.Duration `json:"elapsed"`
+	Error   string        `json:"error,omitempty"`
+	Note    string        `json:"note,omitempty"`
+}
+
+// Member of a run, as recorded in the history
+type memberRecord struct {
+	Repo       string       `json:"repo"`
+	Filesystem string       `json:"filesystem,omitempty"`
+	Failed     bool         `json:"failed"`
+	ReadOnly   bool         `json:"read-only,omitempty"`
+	Steps      []stepRecord `json:"steps"`
+	Variants   []string     `json:"variants,omitempty"`
+	Conflicts  []string     `json:"conflicts,omitempty"`
+}
+
+// One sync or exec run, stored as a line of JSON in the history file
+type runRecord struct {
+	Time     time.Time      `json:"time"`
+	Command  string         `json:"command"`
+	Group    string         `json:"group"`
+	Elapsed  time.Duration  `json:"elapsed"`
+	ExitCode int            `json:"exit-code"`
+	Members  []memberRecord `json:"members"`
+}
+
+// Returns the member's entry in the run, or nil if it took no part
+func (h *runRecord) member(repopath string) *memberRecord {
+	for i := range h.Members {
+		if h.Members[i].Repo == repopath {
+			return &h.Members[i]
+		}
+	}
+	return nil
+}
+
+// History file runs are recorded in. Runs are not recorded if empty.
+var historyPath string
+
+// Configures historyPath from the configuration file
+func configureHistory(config *Config) {
+	historyPath = config.History
+	if historyPath == "" {
+		if dir := stateDir(); dir != "" {
+			historyPath = filepath.Join(dir, "history.jsonl")
+		}
+	}
+}
+
+// Creates the history record of a run of command on group that started at
+// start
+func newRunRecord(command string, group string, start time.Time, results []memberResult, exitCode int) (h *runRecord) {
+	h = &runRecord{
+		Time:     start,
+		Command:  command,
+		Group:    group,
+		Elapsed:  time.Since(start),
+		ExitCode: exitCode,
+	}
+	for i := range results {
+		m := memberRecord{
+			Repo:       results[i].repo,
+			Filesystem: results[i].filesystem,
+			Failed:     results[i].failed(),
+			ReadOnly:   results[i].readOnly,
+			Variants:   results[i].variants,
+			Conflicts:  results[i].conflicts,
+		}
+		for _, j := range results[i].steps {
+			s := stepRecord{Step: j.step, Elapsed: j.elapsed, Note: j.note}
+			if j.err != nil {
+				s.Error = j.err.Error()
+			}
+			m.Steps = append(m.Steps, s)
+		}
+		h.Members = append(h.Members, m)
+	}
+	return
+}
+
+// Appends a run as a line of JSON to the file at path
+func appendHistory(path string, h *runRecord) error {
+	b, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	// A single write, so that concurrent runs don't interleave lines
+	if _, err = f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Records a run in the history file, if there is one
+func recordHistory(h *runRecord) {
+	if historyPath == "" {
+		return
+	}
+	if err := appendHistory(historyPath, h); err != nil {
+		fmt.Println("warning: unable to record run history:", err)
+		appLog.Warn("", "unable to record run history: %v", err)
+	}
+}
+
+// Reads every run in the history file at path, oldest first. A missing file
+// is an empty history.
+func readHistory(path string) (runs []runRecord, err error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	s.Buffer(nil, 16<<20)
+	for s.Scan() {
+		if len(s.Bytes()) == 0 {
+			continue
+		}
+		var h runRecord
+		if err = json.Unmarshal(s.Bytes(), &h); err != nil {
+			// Skip lines left half written by an interrupted run
+			continue
+		}
+		runs = append(runs, h)
+	}
+	return runs, s.Err()
+}
+
+// Returns the runs involving group and the member at repopath. Empty
+// arguments match any group or member.
+func filterHistory(runs []runRecord, group string, repopath string) (filtered []runRecord) {
+	for _, h := range runs {
+		if group != "" && h.Group != group {
+			continue
+		}
+		if repopath != "" && h.member(repopath) == nil {
+			continue
+		}
+		filtered = append(filtered, h)
+	}
+	return
+}
+
+// Prints the run history for the history command
+func historyCmdShow() {
+	if historyPath == "" {
+		fmt.Println("error: no history file configured")
+		os.Exit(exitError)
+	}
+	runs, err := readHistory(historyPath)
+	if err != nil {
+		fmt.Println("error: unable to read run history:", err)
+		os.Exit(exitError)
+	}
+	runs = filterHistory(runs, string(*historyUuid), *historyMember)
+	if *historyLines > 0 && len(runs) > *historyLines {
+		runs = runs[len(runs)-*historyLines:]
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if *historyMember != "" {
+		var lastOk time.Time
+		fmt.Fprintln(w, "TIME\tGROUP\tCOMMAND\tRESULT\tSTEPS")
+		for _, h := range runs {
+			m := h.member(*historyMember)
+			var steps []string
+			for _, i := range m.Steps {
+				status := "ok"
+				if i.Error != "" {
+					status = "FAILED"
+				}
+				steps = append(steps, fmt.Sprintf("%s %s (%v)", i.Step, status, sanePrecision(i.Elapsed)))
+			}
+			result := "ok"
+			if m.Failed {
+				result = "FAILED"
+			} else if h.Command == syncCmd.FullCommand() {
+				lastOk = h.Time
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", h.Time.Local().Format(time.RFC3339), h.Group, h.Command, result, strings.Join(steps, ", "))
+		}
+		w.Flush()
+		if !lastOk.IsZero() {
+			fmt.Println("\nLast synced successfully", lastOk.Local().Format(time.RFC3339))
+		}
+		return
+	}
+	fmt.Fprintln(w, "TIME\tGROUP\tCOMMAND\tMEMBERS\tFAILED\tEXIT\tTOOK")
+	for _, h := range runs {
+		failed := 0
+		for _, i := range h.Members {
+			if i.Failed {
+				failed++
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%d\t%v\n", h.Time.Local().Format(time.RFC3339), h.Group, h.Command, len(h.Members), failed, h.ExitCode, sanePrecision(h.Elapsed))
+	}
+	w.Flush()
+}
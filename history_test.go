@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestHistory(t *testing.T) {
+	repos := fakeRepos(t, 2)
+	path := filepath.Join(repos[0], "state", "history.jsonl")
+	start := time.Now().Add(-time.Minute)
+	results := []memberResult{
+		{repo: repos[0], steps: []stepResult{{step: stepSync, elapsed: 250 * time.Millisecond}}},
+		{repo: repos[1], steps: []stepResult{{step: stepSync}, {step: stepGet, err: errors.New("disk full")}}},
+	}
+	for _, h := range []*runRecord{
+		newRunRecord("sync", "group-a", start, results, exitMembersFailed),
+		newRunRecord("sync", "group-b", start, results[:1], 0),
+	} {
+		if err := appendHistory(path, h); err != nil {
+			t.Fatal(err)
+		}
+	}
+	runs, err := readHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 2 {
+		t.Fatal("expected 2 runs, got", runs)
+	}
+	h := runs[0]
+	if h.Group != "group-a" || h.ExitCode != exitMembersFailed || !h.Time.Equal(start) || h.Elapsed < time.Minute {
+		t.Errorf("unexpected run %+v", h)
+	}
+	m := h.member(repos[1])
+	if m == nil || !m.Failed || len(m.Steps) != 2 || m.Steps[1].Error != "disk full" {
+		t.Errorf("unexpected member %+v", m)
+	}
+	if s := h.member(repos[0]).Steps[0]; s.Elapsed != 250*time.Millisecond {
+		t.Error("unexpected step duration", s.Elapsed)
+	}
+	if f := filterHistory(runs, "group-b", ""); len(f) != 1 || f[0].Group != "group-b" {
+		t.Error("unexpected runs of group-b", f)
+	}
+	if f := filterHistory(runs, "", repos[1]); len(f) != 1 || f[0].Group != "group-a" {
+		t.Error("unexpected runs involving", repos[1], f)
+	}
+	if runs, err = readHistory(filepath.Join(repos[1], "missing.jsonl")); err != nil || len(runs) != 0 {
+		t.Error("expected an empty history, got", runs, err)
+	}
+}
+
+func TestSanePrecision(t *testing.T) {
+	for in, out := range map[time.Duration]time.Duration{
+		1500 * time.Nanosecond:                2 * time.Microsecond,
+		250 * time.Millisecond:                250 * time.Millisecond,
+		1234 * time.Millisecond:               time.Second,
+		90*time.Second + 400*time.Millisecond: 90 * time.Second,
+		2*time.Hour + 40*time.Second:          2*time.Hour + time.Minute,
+	} {
+		// Repeated, as rounding once depended on map order
+		for i := 0; i < 20; i++ {
+			if d := sanePrecision(in); d != out {
+				t.Fatalf("sanePrecision(%v) = %v, expected %v", in, d, out)
+			}
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	sh "github.com/codeskyblue/go-sh"
+)
+
+// Events that hooks can be attached to
+const (
+	// Before looking for group members. Only configured hooks run, since
+	// hooks inside repositories can't be found yet.
+	hookPreDiscovery = "pre-discovery"
+	// Before a member is synced. If a pre-sync hook fails, the member is
+	// skipped.
+	hookPreSync = "pre-sync"
+	// After a member is synced, whether or not it succeeded
+	hookPostSync = "post-sync"
+	// After a member is synced, if any step failed
+	hookOnFailure = "on-failure"
+)
+
+// Directory within a repository holding hook executables named after events
+const hookDir = ".autoannex/hooks"
+
+// Runs the hooks for event in order, stopping at the first failure. Hooks
+// given as commands run with sh -c, and executables run directly, all in dir
+// if it exists. group, repopath and results are passed in the environment.
+func runHooks(event string, hooks []string, dir string, group string, repopath string, results []stepResult) error {
+	if len(hooks) == 0 {
+		return nil
+	}
+	env := map[string]string{
+		"AUTOANNEX_EVENT": event,
+		"AUTOANNEX_GROUP": group,
+		"AUTOANNEX_REPO":  repopath,
+	}
+	if results != nil {
+		var steps, failed []string
+		for _, i := range results {
+			if i.err != nil {
+				steps = append(steps, i.step+"=failed")
+				failed = append(failed, i.step)
+			} else {
+				steps = append(steps, i.step+"=ok")
+			}
+		}
+		env["AUTOANNEX_STEPS"] = strings.Join(steps, " ")
+		env["AUTOANNEX_FAILED_STEPS"] = strings.Join(failed, " ")
+		if len(failed) > 0 {
+			env["AUTOANNEX_RESULT"] = "failed"
+		} else {
+			env["AUTOANNEX_RESULT"] = "ok"
+		}
+	}
+	for _, hook := range hooks {
+		fmt.Println("Running", event, "hook", hook, "...")
+		appLog.Debug(repopath, "running %s hook %s", event, hook)
+		s := sh.NewSession()
+		for k, v := range env {
+			s.SetEnv(k, v)
+		}
+		// Relative paths are relative to where the hook runs
+		path := hook
+		if st, err := os.Stat(dir); err == nil && st.IsDir() {
+			s.SetDir(dir)
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(dir, hook)
+			}
+		}
+		var err error
+		if st, serr := os.Stat(path); serr == nil && !st.IsDir() && st.Mode()&0111 != 0 {
+			err = s.Command(path).Run()
+		} else {
+			err = s.Command("sh", "-c", hook).Run()
+		}
+		if err != nil {
+			return fmt.Errorf("%s hook %q failed: %v", event, hook, err)
+		}
+	}
+	return nil
+}
+
+// Returns the hooks to run for event on the member at repopath: those
+// configured for its group, then for the member, then, if enabled with
+// repo-hooks, found in the repository's hook directory
+func memberHooks(g *GroupConfig, repopath string, event string) (hooks []string) {
+	m := g.Member(repopath)
+	hooks = append(hooks, g.Hooks[event]...)
+	hooks = append(hooks, m.Hooks[event]...)
+	if !g.RepoHooks && !m.RepoHooks {
+		return
+	}
+	f := filepath.Join(repopath, hookDir, event)
+	if st, err := os.Stat(f); err == nil && !st.IsDir() && st.Mode()&0111 != 0 {
+		hooks = append(hooks, f)
+	}
+	return
+}
+
+// Runs pre-discovery hooks configured for a group and for each of its
+// configured members. Failures are reported but don't stop discovery.
+func runPreDiscoveryHooks(g *GroupConfig, group string) {
+	if err := runHooks(hookPreDiscovery, g.Hooks[hookPreDiscovery], "", group, "", nil); err != nil {
+		fmt.Println("error:", err)
+		appLog.Error("", "%v", err)
+	}
+	for repopath, m := range g.Members {
+		if m == nil {
+			continue
+		}
+		if err := runHooks(hookPreDiscovery, m.Hooks[hookPreDiscovery], "", group, repopath, nil); err != nil {
+			fmt.Println("error:", err)
+			appLog.Error(repopath, "%v", err)
+		}
+	}
+}
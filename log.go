@@ -0,0 +1,373 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Severity of a log entry
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var levelNames = []string{"DEBUG", "INFO", "WARN", "ERROR"}
+
+func (l logLevel) String() string {
+	return levelNames[l]
+}
+
+// Parses a level name, in any case
+func parseLevel(s string) (logLevel, error) {
+	for i, j := range levelNames {
+		if strings.EqualFold(s, j) {
+			return logLevel(i), nil
+		}
+	}
+	return levelInfo, errors.New("unknown log level " + s)
+}
+
+// Defaults for log rotation
+const (
+	defaultLogMaxSize = 1 << 20
+	defaultLogKeep    = 3
+)
+
+// Name of each member's own log, within its .git directory
+const repoLogName = "logs/autoannex.log"
+
+// A log file that is only ever appended to. Once it grows past maxSize it is
+// renamed to path.1, path.1 to path.2 and so on, keeping at most keep old
+// files.
+type logFile struct {
+	path    string
+	maxSize int64
+	keep    int
+	// Whether only the log's own directory may be created, not its parents.
+	// Creating a member's .git would make it look like a repository.
+	dirOnly bool
+}
+
+func (f *logFile) append(b []byte) error {
+	if f.dirOnly {
+		if err := os.Mkdir(filepath.Dir(f.path), 0755); err != nil && !os.IsExist(err) {
+			return err
+		}
+	} else if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return err
+	}
+	if s, err := os.Stat(f.path); err == nil && f.maxSize > 0 && s.Size()+int64(len(b)) > f.maxSize {
+		if err = f.rotate(); err != nil {
+			return err
+		}
+	}
+	o, err := os.OpenFile(f.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err = o.Write(b); err != nil {
+		o.Close()
+		return err
+	}
+	return o.Close()
+}
+
+func (f *logFile) rotate() error {
+	os.Remove(f.rotated(f.keep))
+	for i := f.keep - 1; i > 0; i-- {
+		if err := os.Rename(f.rotated(i), f.rotated(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if f.keep == 0 {
+		return os.Remove(f.path)
+	}
+	return os.Rename(f.path, f.rotated(1))
+}
+
+func (f *logFile) rotated(i int) string {
+	return fmt.Sprintf("%s.%d", f.path, i)
+}
+
+// Returns the files making up the log that exist, oldest first
+func (f *logFile) files() (files []string) {
+	for i := f.keep; i > 0; i-- {
+		if _, err := os.Stat(f.rotated(i)); err == nil {
+			files = append(files, f.rotated(i))
+		}
+	}
+	if _, err := os.Stat(f.path); err == nil {
+		files = append(files, f.path)
+	}
+	return
+}
+
+// Writes timestamped entries to the central log and, if enabled, to the log
+// of the member they concern
+type logger struct {
+	lock  sync.Mutex
+	level logLevel
+	// The central log. Entries are not written anywhere if nil.
+	central *logFile
+	// Whether entries about a member are also written to its own log
+	repoLogs bool
+	maxSize  int64
+	keep     int
+	// Logs that couldn't be written to, which are only reported once
+	failed map[string]bool
+	now    func() time.Time
+	// Members whose own logs aren't written, such as those on read-only
+	// filesystems
+	skip map[string]bool
+}
+
+// Until configured, entries are discarded
+var appLog = &logger{level: levelInfo}
+
+// Returns the directory autoannex keeps its logs and history in
+func stateDir() string {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		usr, err := user.Current()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(usr.HomeDir, ".local", "state")
+	}
+	return filepath.Join(dir, "autoannex")
+}
+
+// Returns the default central log location
+func defaultLogPath() string {
+	if dir := stateDir(); dir != "" {
+		return filepath.Join(dir, "autoannex.log")
+	}
+	return ""
+}
+
+// Returns the log of the member at repopath. SSH members, and paths without
+// a .git directory, have no log.
+func (l *logger) repoLog(repopath string) *logFile {
+	if _, _, ok := sshMember(repopath); ok || l.skip[repopath] {
+		return nil
+	}
+	// Not a repository, or one whose .git is a file
+	if st, err := os.Stat(filepath.Join(repopath, ".git")); err != nil || !st.IsDir() {
+		return nil
+	}
+	return &logFile{path: filepath.Join(repopath, ".git", repoLogName), maxSize: l.maxSize, keep: l.keep, dirOnly: true}
+}
+
+// Adds an entry about the member at repopath, or about no member in
+// particular if repopath is empty. Continuation lines of multi-line messages
+// are indented with a tab.
+func (l *logger) logf(level logLevel, repopath string, format string, a ...interface{}) {
+	if level < l.level {
+		return
+	}
+	now := time.Now
+	if l.now != nil {
+		now = l.now
+	}
+	msg := strings.TrimRight(fmt.Sprintf(format, a...), "\n")
+	msg = strings.Replace(msg, "\n", "\n\t", -1)
+	entry := now().Format(time.RFC3339) + " " + level.String()
+	if repopath != "" {
+		entry += " [" + repopath + "]"
+	}
+	entry += " " + msg + "\n"
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.central != nil {
+		l.write(l.central, entry)
+	}
+	if l.repoLogs && repopath != "" {
+		if f := l.repoLog(repopath); f != nil {
+			l.write(f, entry)
+		}
+	}
+}
+
+// Stops writing entries about the member at repopath to its own log
+func (l *logger) skipRepoLog(repopath string) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.skip == nil {
+		l.skip = make(map[string]bool)
+	}
+	l.skip[repopath] = true
+}
+
+func (l *logger) write(f *logFile, entry string) {
+	err := f.append([]byte(entry))
+	if err != nil && !l.failed[f.path] {
+		if l.failed == nil {
+			l.failed = make(map[string]bool)
+		}
+		l.failed[f.path] = true
+		fmt.Fprintln(os.Stderr, "warning: unable to write log:", err)
+	}
+}
+
+func (l *logger) Debug(repopath string, format string, a ...interface{}) {
+	l.logf(levelDebug, repopath, format, a...)
+}
+
+func (l *logger) Info(repopath string, format string, a ...interface{}) {
+	l.logf(levelInfo, repopath, format, a...)
+}
+
+func (l *logger) Warn(repopath string, format string, a ...interface{}) {
+	l.logf(levelWarn, repopath, format, a...)
+}
+
+func (l *logger) Error(repopath string, format string, a ...interface{}) {
+	l.logf(levelError, repopath, format, a...)
+}
+
+// Configures appLog from the configuration file and command line
+func configureLog(config *Config) error {
+	c := config.Log
+	appLog.level = levelInfo
+	if c.Level != "" {
+		level, err := parseLevel(c.Level)
+		if err != nil {
+			return err
+		}
+		appLog.level = level
+	}
+	if *appLogLevel != "" {
+		appLog.level, _ = parseLevel(*appLogLevel)
+	}
+	appLog.maxSize, appLog.keep = c.MaxSize, defaultLogKeep
+	if appLog.maxSize == 0 {
+		appLog.maxSize = defaultLogMaxSize
+	}
+	if c.Keep != nil {
+		if *c.Keep < 0 {
+			return fmt.Errorf("invalid number of logs to keep %d", *c.Keep)
+		}
+		appLog.keep = *c.Keep
+	}
+	appLog.repoLogs = c.RepoLogs
+	path := c.File
+	if path == "" {
+		path = defaultLogPath()
+	}
+	if path != "" {
+		appLog.central = &logFile{path: path, maxSize: appLog.maxSize, keep: appLog.keep}
+	}
+	return nil
+}
+
+// Logs the outcome of a step on the member at repopath, and tells the user
+// where to find the details if it failed
+func logStep(repopath string, step string, elapsed time.Duration, err error) {
+	if err == nil {
+		appLog.Info(repopath, "%s done in %v", step, sanePrecision(elapsed))
+		return
+	}
+	appLog.Error(repopath, "%s failed after %v:\n%v", step, sanePrecision(elapsed), err)
+	if appLog.central == nil && !appLog.repoLogs {
+		fmt.Println("There were", step, "errors:\n", err)
+		return
+	}
+	fmt.Println("There were", step, "errors. To see them, run: autoannex log --member", repopath)
+}
+
+// One entry of a log, including its continuation lines
+type logEntry struct {
+	level logLevel
+	repo  string
+	text  string
+}
+
+// Reads the entries of the given log files, in order
+func readLog(files []string) (entries []logEntry, err error) {
+	for _, i := range files {
+		f, err := os.Open(i)
+		if err != nil {
+			return entries, err
+		}
+		s := bufio.NewScanner(f)
+		s.Buffer(nil, 1<<20)
+		for s.Scan() {
+			line := s.Text()
+			if strings.HasPrefix(line, "\t") && len(entries) > 0 {
+				entries[len(entries)-1].text += "\n" + line
+				continue
+			}
+			entries = append(entries, parseLogLine(line))
+		}
+		f.Close()
+		if err = s.Err(); err != nil {
+			return entries, err
+		}
+	}
+	return
+}
+
+// Parses the first line of an entry, of the form
+// "<time> <LEVEL> [<repo>] <message>" where the repo is optional
+func parseLogLine(line string) (e logEntry) {
+	e.text = line
+	f := strings.SplitN(line, " ", 3)
+	if len(f) < 2 {
+		return
+	}
+	e.level, _ = parseLevel(f[1])
+	if len(f) == 3 && strings.HasPrefix(f[2], "[") {
+		if end := strings.Index(f[2], "] "); end > 0 {
+			e.repo = f[2][1:end]
+		}
+	}
+	return
+}
+
+// Prints the central log, or a member's log, for the log command
+func logCmdShow() {
+	f := appLog.central
+	if *logMember != "" && appLog.repoLogs {
+		// The member's own log survives rotation of the central log
+		if m := appLog.repoLog(*logMember); m != nil {
+			f = m
+		}
+	}
+	if f == nil {
+		fmt.Println("error: no log to show")
+		os.Exit(exitError)
+	}
+	entries, err := readLog(f.files())
+	if err != nil {
+		fmt.Println("error: unable to read log:", err)
+		os.Exit(exitError)
+	}
+	min, _ := parseLevel(*logMinLevel)
+	var shown []logEntry
+	for _, e := range entries {
+		if e.level < min {
+			continue
+		}
+		if *logMember != "" && e.repo != *logMember {
+			continue
+		}
+		shown = append(shown, e)
+	}
+	if *logLines > 0 && len(shown) > *logLines {
+		shown = shown[len(shown)-*logLines:]
+	}
+	for _, e := range shown {
+		fmt.Println(e.text)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-yaml/yaml"
+)
+
+func TestLogRotation(t *testing.T) {
+	td, err := ioutil.TempDir("", "autoannex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	f := &logFile{path: filepath.Join(td, "state", "autoannex.log"), maxSize: 10, keep: 2}
+	for _, i := range []string{"aaaaaaaa\n", "bbbbbbbb\n", "cccccccc\n", "dddddddd\n"} {
+		if err = f.append([]byte(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var got []string
+	for _, i := range f.files() {
+		b, err := ioutil.ReadFile(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, string(b))
+	}
+	// The oldest entry has been rotated away
+	if strings.Join(got, "") != "bbbbbbbb\ncccccccc\ndddddddd\n" {
+		t.Errorf("unexpected log contents %q", got)
+	}
+}
+
+func TestLogKeep(t *testing.T) {
+	repos := fakeRepos(t, 1)
+	defer func(l *logger) { appLog = l }(appLog)
+	appLog = &logger{}
+	for in, keep := range map[string]int{"": defaultLogKeep, "keep: 0": 0, "keep: 5": 5} {
+		config := &Config{}
+		if err := yaml.Unmarshal([]byte("log:\n  file: "+filepath.Join(repos[0], "a.log")+"\n  "+in), config); err != nil {
+			t.Fatal(err)
+		}
+		if err := configureLog(config); err != nil || appLog.keep != keep || appLog.central.keep != keep {
+			t.Errorf("%q: expected to keep %d logs, got %d (%v)", in, keep, appLog.keep, err)
+		}
+	}
+	// With none kept, rotation starts the log afresh
+	f := &logFile{path: filepath.Join(repos[0], "b.log"), maxSize: 10}
+	for _, i := range []string{"aaaaaaaa\n", "bbbbbbbb\n"} {
+		if err := f.append([]byte(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if files := f.files(); len(files) != 1 {
+		t.Error("expected only the current log, got", files)
+	}
+	keep := -1
+	if err := configureLog(&Config{Log: LogConfig{Keep: &keep}}); err == nil {
+		t.Error("expected an error keeping -1 logs")
+	}
+}
+
+func TestLogEntries(t *testing.T) {
+	repos := fakeRepos(t, 1)
+	central := filepath.Join(repos[0], "central.log")
+	l := &logger{
+		level:    levelInfo,
+		central:  &logFile{path: central},
+		repoLogs: true,
+		now:      func() time.Time { return time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC) },
+	}
+	l.Debug(repos[0], "hidden")
+	l.Info("", "starting")
+	l.Error(repos[0], "sync failed:\n%v", errors.New("first\nsecond"))
+	entries, err := readLog([]string{central})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 {
+		t.Fatal("expected 2 entries, got", entries)
+	}
+	if entries[0].level != levelInfo || entries[0].repo != "" || entries[0].text != "2020-01-02T03:04:05Z INFO starting" {
+		t.Error("unexpected entry", entries[0])
+	}
+	expected := "2020-01-02T03:04:05Z ERROR [" + repos[0] + "] sync failed:\n\tfirst\n\tsecond"
+	if entries[1].level != levelError || entries[1].repo != repos[0] || entries[1].text != expected {
+		t.Errorf("unexpected entry %+v", entries[1])
+	}
+	// Entries about the member are also in its own log
+	entries, err = readLog([]string{filepath.Join(repos[0], ".git", repoLogName)})
+	if err != nil || len(entries) != 1 || entries[0].text != expected {
+		t.Error("unexpected member log", entries, err)
+	}
+}
+
+func TestLogNotRepository(t *testing.T) {
+	repos := fakeRepos(t, 1)
+	dir := filepath.Join(repos[0], "not-a-repo")
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	l := &logger{level: levelInfo, central: &logFile{path: filepath.Join(repos[0], "central.log")}, repoLogs: true}
+	l.Error(dir, "open failed")
+	// Creating .git would turn the directory into a repository
+	if _, err := os.Stat(filepath.Join(dir, ".git")); !os.IsNotExist(err) {
+		t.Error("expected no .git to be created, got", err)
+	}
+	l.Info(repos[0], "synced")
+	if _, err := os.Stat(filepath.Join(repos[0], ".git", repoLogName)); err != nil {
+		t.Error("expected a log in the repository,", err)
+	}
+}
+
+func TestLogUnwritable(t *testing.T) {
+	repos := fakeRepos(t, 1)
+	// A file where the log's directory should be
+	blocker := filepath.Join(repos[0], "blocker")
+	if err := ioutil.WriteFile(blocker, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	l := &logger{level: levelInfo, central: &logFile{path: filepath.Join(blocker, "autoannex.log")}}
+	l.Info("", "first")
+	l.Info("", "second")
+	if !l.failed[l.central.path] {
+		t.Error("expected the failed write to be recorded")
+	}
+}
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"errors"
 	"fmt"
-	"io/ioutil"
+	"hash/fnv"
 	"os"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -25,20 +27,71 @@ var (
 	appSigFilename = app.Flag("sig-file", "Signature filename to look for").Default(DEFAULT_SIGNATURE_FILENAME).Short('s').String()
 	appSshHosts    = app.Flag("ssh-hosts", "Also look for remote repos on these comma-separated SSH hosts").String()
 	appDepth       = app.Flag("depth", "Maximum search depth (default 1)").Default("1").Short('d').Uint()
+	appConfig      = app.Flag("config", "Configuration file").Default(defaultConfigPath()).String()
+	appGit         = app.Flag("git", "Path of the git executable to run").String()
+	appGitAnnex    = app.Flag("git-annex", "Path of the git-annex executable to run").String()
+	appEnv         = app.Flag("env", "Set an environment variable for git and git-annex, as KEY=value").StringMap()
+	appLogLevel    = app.Flag("log-level", "Minimum level to log").Enum("debug", "info", "warn", "error")
 
 	syncCmd       = app.Command("sync", "Synchronize a group of repositories")
-	syncUuid      = Uuid(syncCmd.Arg("uuid", "Signature UUID of directory group to synchronize").Required())
+	syncUuids     = UuidList(syncCmd.Arg("uuid", "Signature UUIDs of directory groups to synchronize"))
+	syncAll       = syncCmd.Flag("all", "Synchronize every group found with at least two members").Bool()
+	syncTags      = syncCmd.Flag("tag", "Only synchronize groups with this tag in the configuration").Strings()
+	syncJson      = syncCmd.Flag("json", "Write each group's results, including conflicts, as a line of JSON to this file").String()
+	syncSafeDrop  = syncCmd.Flag("conflicts-block-drop", "Don't drop content from members with merge conflicts or new variant files").Bool()
+	syncReserve   = syncCmd.Flag("disk-reserve", "Space to leave free on each member when getting content, eg 10G").String()
+	syncOn        = syncCmd.Flag("on", "Only synchronize groups with a member under this path, such as a newly mounted drive. May be repeated").ExistingDirs()
 	syncRmRemotes = syncCmd.Flag("remove-remotes", "Remove all remotes from all repos before synchronizing").Default("false").Bool()
 	syncDrop      = syncCmd.Flag("drop", "Run git-annex drop --auto on each repository").Short('D').Default("false").Bool()
 	syncGet       = syncCmd.Flag("get", "Run git-annex get --auto on each repository").Short('g').Default("false").Bool()
 	syncFastFsck  = syncCmd.Flag("fast-fsck", "Run git-annex fsck --fast --quiet on each repository").Short('F').Default("false").Bool()
 	syncAdd       = syncCmd.Flag("add", "Run git-annex add . on each repository before syncing").Short('A').Default("false").Bool()
+	syncCopyTo    = syncCmd.Flag("copy-to", "Copy all content from every other member to this member (path or host:path)").String()
+	syncJobs      = syncCmd.Flag("jobs", "Number of parallel transfers to use with --copy-to").Short('J').Default("0").Int()
 
 	exec         = app.Command("exec", "Execute an arbitrary git command on all discovered repositories")
 	execUuid     = Uuid(exec.Arg("uuid", "Signature UUID of directory group to execute on").Required())
 	execCmd      = StringList(exec.Arg("command", "Git command to execute").Required())
 	execParallel = exec.Flag("parallel", "Execute command on all repositories in parallel").Short('p').Bool()
 
+	policy        = app.Command("policy", "Apply preferred content policies to a group of repositories")
+	policyUuid    = Uuid(policy.Arg("uuid", "Signature UUID of directory group to configure").Required())
+	policyMembers = policy.Flag("member", "Set the policy of a member, as path=policy. Overrides the configuration file").StringMap()
+
+	trust       = app.Command("trust", "Set the trust level of a member in every member of its group")
+	trustUuid   = Uuid(trust.Arg("uuid", "Signature UUID of the member's directory group").Required())
+	trustMember = trust.Arg("member", "Member path, host:path, or git-annex repository UUID").Required().String()
+	trustLevel  = trust.Arg("level", "Trust level").Required().Enum("trusted", "semitrusted", "untrusted", "dead")
+
+	daemonCmd      = app.Command("daemon", "Sync the groups with members on each filesystem as it is mounted")
+	daemonDebounce = daemonCmd.Flag("debounce", "Time to wait for further mounts before syncing").Default("5s").Duration()
+	daemonSyncArgs = daemonCmd.Arg("sync-args", "Extra arguments for sync, after --, eg -- --get --drop").Strings()
+
+	logCmd      = app.Command("log", "Show the autoannex log")
+	logMember   = logCmd.Flag("member", "Only show entries about this member").String()
+	logMinLevel = logCmd.Flag("level", "Only show entries at or above this level").Default("debug").Enum("debug", "info", "warn", "error")
+	logLines    = logCmd.Flag("lines", "Only show the last n entries").Short('n').Int()
+
+	statusCmd   = app.Command("status", "Show the members of groups and the filesystems they are on")
+	statusUuids = UuidList(statusCmd.Arg("uuid", "Only show these directory groups"))
+
+	historyCmd    = app.Command("history", "Show past sync and exec runs")
+	historyUuid   = Uuid(historyCmd.Arg("uuid", "Only show runs of this directory group"))
+	historyMember = historyCmd.Flag("member", "Only show runs involving this member, with its steps").String()
+	historyLines  = historyCmd.Flag("lines", "Only show the last n runs").Short('n').Int()
+
+	installService         = app.Command("install-service", "Generate systemd user units that sync configured groups on a schedule")
+	installServiceDir      = installService.Flag("dir", "Directory to write the units to").Default(defaultUnitDir()).String()
+	installServiceGroups   = UuidList(installService.Flag("group", "Generate units for this group, instead of every configured group"))
+	installServiceCalendar = installService.Flag("on-calendar", "When to sync, as a systemd calendar event. Overrides each group's schedule").String()
+	installServiceMounts   = installService.Flag("mount", "Also sync the groups with members on this mount point whenever it is mounted").Strings()
+	installServiceDaemon   = installService.Flag("daemon", "Also run the daemon, which syncs groups on any filesystem as it is mounted").Bool()
+	installServiceEnable   = installService.Flag("enable", "Reload systemd and enable the units").Bool()
+
+	uninstallService        = app.Command("uninstall-service", "Remove the systemd user units generated by install-service")
+	uninstallServiceDir     = uninstallService.Flag("dir", "Directory the units were written to").Default(defaultUnitDir()).String()
+	uninstallServiceDisable = uninstallService.Flag("disable", "Stop and disable the units, then reload systemd").Bool()
+
 	sig         = app.Command("sig", "Manage signature files")
 	sigFind     = sig.Command("find", "Search for signature files")
 	sigFindUuid = sigFind.Flag("uuid", "Only look for this signature UUID").String()
@@ -67,6 +120,30 @@ func Uuid(s kingpin.Settings) (target *UuidValue) {
 	return
 }
 
+type uuidList []string
+
+func (ul *uuidList) Set(s string) (err error) {
+	if _, err = uuid.ParseHex(s); err != nil {
+		return
+	}
+	*ul = append(*ul, s)
+	return nil
+}
+
+func (ul *uuidList) String() string {
+	return strings.Join(*ul, " ")
+}
+
+func (ul *uuidList) IsCumulative() bool {
+	return true
+}
+
+func UuidList(s kingpin.Settings) (target *[]string) {
+	target = new([]string)
+	s.SetValue((*uuidList)(target))
+	return
+}
+
 type stringList []string
 
 func (sl *stringList) Set(s string) (err error) {
@@ -88,33 +165,67 @@ func StringList(s kingpin.Settings) (target *[]string) {
 	return
 }
 
-// Search for signature files on remote hosts via SSH
-func findSshRepos(uuid UuidValue) (sshRepos []string) {
+// Search for signature files on remote hosts via SSH. Returns an error
+// naming the hosts that couldn't be searched, along with any repos found on
+// the others.
+func findSshRepos(uuid UuidValue) (sshRepos []string, err error) {
 	if uuid == "" {
 		panic("uuid required")
 	}
+	groups, err := findSshGroups([]string{string(uuid)})
+	return groups[string(uuid)], err
+}
+
+// Search for members of the given groups, or of every group if uuids is
+// nil, on remote hosts via SSH. Returns the host:path of the members found,
+// keyed by group, and an error naming the hosts that couldn't be searched.
+func findSshGroups(uuids []string) (sshGroups map[string][]string, err error) {
+	sshGroups = make(map[string][]string)
 	if *appSshHosts == "" {
-		return nil
+		return sshGroups, nil
+	}
+	wanted := func(group string) bool {
+		if uuids == nil {
+			return true
+		}
+		for _, i := range uuids {
+			if i == group {
+				return true
+			}
+		}
+		return false
 	}
 	hosts := strings.Split(*appSshHosts, ",")
-	collect := make(chan string)
+	collect := make(chan [2]string)
 	wg := sync.WaitGroup{}
+	failedLock := sync.Mutex{}
+	var failed []string
+	fail := func(host string) {
+		failedLock.Lock()
+		failed = append(failed, host)
+		failedLock.Unlock()
+	}
 	for _, i := range hosts {
 		// Spawn workers
 		wg.Add(1)
 		go func(host string) {
 			defer wg.Done()
 			fmt.Println("Looking for repos on", host)
-			sshDirsigOut, err := sh.Command(
-				"ssh", host,
+			args := []interface{}{
+				host,
 				"autoannex", "sig", "find",
-				"--uuid", string(uuid),
 				"--sig-file", *appSigFilename,
 				"-d", strconv.FormatUint(uint64(*appDepth), 10),
-			).Output()
+			}
+			if len(uuids) == 1 {
+				args = append(args, "--uuid", uuids[0])
+			}
+			sshDirsigOut, err := sh.Command("ssh", args...).Output()
 			if err != nil {
 				fmt.Println("Error looking for repos on", host)
 				fmt.Println(string(sshDirsigOut))
+				appLog.Warn("", "error looking for repos on %s: %v\n%s", host, err, sshDirsigOut)
+				fail(host)
 				return
 			}
 			g := make(map[string][]string)
@@ -122,13 +233,21 @@ func findSshRepos(uuid UuidValue) (sshRepos []string) {
 			if err != nil {
 				fmt.Println("Error parsing SSH host output from", host, "\n", err)
 				fmt.Println(string(sshDirsigOut))
+				appLog.Warn("", "error parsing SSH host output from %s: %v\n%s", host, err, sshDirsigOut)
+				fail(host)
 				return
 			}
-			repos := g[string(uuid)]
-			for _, j := range repos {
-				collect <- host + ":" + j
+			n := 0
+			for group, repos := range g {
+				if !wanted(group) {
+					continue
+				}
+				for _, j := range repos {
+					collect <- [2]string{group, host + ":" + j}
+					n++
+				}
 			}
-			fmt.Println("Found", len(repos), "repo(s) on", host)
+			fmt.Println("Found", n, "repo(s) on", host)
 		}(i)
 	}
 	// Collect results
@@ -137,156 +256,95 @@ func findSshRepos(uuid UuidValue) (sshRepos []string) {
 		close(collect)
 	}()
 	for i := range collect {
-		sshRepos = append(sshRepos, i)
+		sshGroups[i[0]] = append(sshGroups[i[0]], i[1])
+	}
+	if len(failed) > 0 {
+		err = errors.New("unable to search SSH host(s) " + strings.Join(failed, ", "))
+	}
+	return
+}
+
+// Configures how git and git-annex are run, from the configuration file and
+// command line
+func configureRunner(config *Config) {
+	r := goannex.DefaultRunner
+	r.Git, r.GitAnnex = config.Git, config.GitAnnex
+	if *appGit != "" {
+		r.Git = *appGit
+	}
+	if *appGitAnnex != "" {
+		r.GitAnnex = *appGitAnnex
+	}
+	env := make(map[string]string)
+	for k, v := range config.Env {
+		env[k] = v
+	}
+	for k, v := range *appEnv {
+		env[k] = v
+	}
+	r.Env = envList(env)
+}
+
+// Returns env as KEY=value strings, sorted so that commands built from them
+// are stable
+func envList(env map[string]string) (list []string) {
+	var keys []string
+	for k := range env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		list = append(list, k+"="+env[k])
 	}
 	return
 }
 
 func main() {
-	switch kingpin.MustParse(app.Parse(os.Args[1:])) {
-	case syncCmd.FullCommand():
-		// sync
-		sshRepos := findSshRepos(*syncUuid)
-		groups := dirsig.Find(*appSigFilename, "", *appDepth)
-		if repos, ok := groups[string(*syncUuid)]; ok {
-			fmt.Println("Found repository group", *syncUuid, "with", len(repos), "members")
-		nextRepo:
-			for _, repopath := range repos {
-				r, err := goannex.OpenRepo(repopath)
-				if err != nil {
-					logFile := repopath + "/.git/logs/autoannex.open.log"
-					ioutil.WriteFile(logFile, []byte(err.Error()), 0644)
-					fmt.Println("There were internal errors. They have been saved to", logFile)
-					continue nextRepo
-				}
-				// Clean up old remotes
-				for remote := range r.Remotes() {
-					if *syncRmRemotes || strings.HasPrefix(remote, "autoannex-") {
-						err = r.RemoveRemote(remote)
-						if err != nil {
-							logFile := repopath + "/.git/logs/autoannex.rrem.log"
-							ioutil.WriteFile(logFile, []byte(err.Error()), 0644)
-							fmt.Println("There were internal errors. They have been saved to", logFile)
-							continue nextRepo
-						}
-					}
-				}
-				// Fully connect found repositories
-				for j, remotepath := range repos {
-					if remotepath == repopath {
-						// Don't add a remote to ourselves
-						continue
-					}
-					err = r.AddRemote("autoannex-"+strconv.Itoa(j), remotepath)
-					if err != nil {
-						logFile := repopath + "/.git/logs/autoannex.rem.log"
-						ioutil.WriteFile(logFile, []byte(err.Error()), 0644)
-						fmt.Println("There were internal errors. They have been saved to", logFile)
-						continue nextRepo
-					}
-				}
-				for j, remotepath := range sshRepos {
-					err = r.AddRemote("autoannex-extra"+strconv.Itoa(j), remotepath)
-					if err != nil {
-						logFile := repopath + "/.git/logs/autoannex.extrarem.log"
-						ioutil.WriteFile(logFile, []byte(err.Error()), 0644)
-						fmt.Println("There were internal errors. They have been saved to", logFile)
-						continue nextRepo
-					}
-				}
-				// Add .
-				if *syncAdd {
-					fmt.Println("Adding new files in", repopath, "...")
-					start := time.Now()
-					err := r.Add(".")
-					fmt.Println("Done. Took", sanePrecision(time.Since(start)))
-					if err != nil {
-						logFile := repopath + "/.git/logs/autoannex.add.log"
-						ioutil.WriteFile(logFile, []byte(err.Error()), 0644)
-						fmt.Println("There were add errors. They have been saved to", logFile)
-					}
-				}
-				// Sync
-				fmt.Println("Now syncing", repopath, "...")
-				start := time.Now()
-				err = r.Sync()
-				fmt.Println("Done. Took", sanePrecision(time.Since(start)))
-				if err != nil {
-					logFile := repopath + "/.git/logs/autoannex.sync.log"
-					ioutil.WriteFile(logFile, []byte(err.Error()), 0644)
-					fmt.Println("There were sync errors. They have been saved to", logFile)
-				}
-				// Drop --auto
-				if *syncDrop {
-					fmt.Println("Dropping unneeded data from", repopath, "...")
-					start := time.Now()
-					err = r.DropAuto()
-					fmt.Println("Done. Took", sanePrecision(time.Since(start)))
-					if err != nil {
-						logFile := repopath + "/.git/logs/autoannex.drop.log"
-						ioutil.WriteFile(logFile, []byte(err.Error()), 0644)
-						fmt.Println("There were drop errors. They have been saved to", logFile)
-					}
-				}
-				// Get --auto
-				if *syncGet {
-					fmt.Println("Copying data to", repopath, "...")
-					start := time.Now()
-					err = r.GetAuto()
-					fmt.Println("Done. Took", sanePrecision(time.Since(start)))
-					if err != nil {
-						logFile := repopath + "/.git/logs/autoannex.get.log"
-						ioutil.WriteFile(logFile, []byte(err.Error()), 0644)
-						fmt.Println("There were get errors. They have been saved to", logFile)
-					}
-				}
-				// Fast fsck
-				if *syncFastFsck {
-					fmt.Println("Running fast fsck on", repopath, "...")
-					start := time.Now()
-					err = r.FastFsck()
-					fmt.Println("Done. Took", sanePrecision(time.Since(start)))
-					if err != nil {
-						logFile := repopath + "/.git/logs/autoannex.fsck.log"
-						ioutil.WriteFile(logFile, []byte(err.Error()), 0644)
-						fmt.Println("There were fsck errors. They have been saved to", logFile)
-					}
-				}
-			}
-			if *syncGet || *syncFastFsck || *syncDrop {
-				// Resync if things may have changed
-				for _, repopath := range repos {
-					fmt.Println("Now resyncing", repopath, "...")
-					r, err := goannex.OpenRepo(repopath)
-					if err != nil {
-						fmt.Println("Resync errors:\n", err)
-					}
-					start := time.Now()
-					err = r.Sync()
-					fmt.Println("Done. Took", sanePrecision(time.Since(start)))
-					if err != nil {
-						logFile := repopath + "/.git/logs/autoannex.resync.log"
-						ioutil.WriteFile(logFile, []byte(err.Error()), 0644)
-						fmt.Println("There were resync errors. They have been saved to", logFile)
-					}
-				}
-			}
-		} else {
-			fmt.Println("error: could not find any members of\nrepository group", *syncUuid)
-			fmt.Println("try increasing maximum search depth")
-			return
+	command := kingpin.MustParse(app.Parse(os.Args[1:]))
+	config, err := loadConfig(*appConfig)
+	if err != nil {
+		err = errors.New("unable to read configuration: " + err.Error())
+	} else if err = configureLog(config); err == nil {
+		err = configureDiscovery(config)
+	}
+	if err != nil {
+		// Other hosts run sig find over SSH, so a bad configuration here
+		// mustn't stop them finding this host's members
+		if command != sigFind.FullCommand() && command != sigNew.FullCommand() && command != statusCmd.FullCommand() {
+			fmt.Println("error:", err)
+			os.Exit(exitError)
 		}
+		fmt.Fprintln(os.Stderr, "warning:", err.Error()+", using the default configuration")
+		config = &Config{}
+		configureLog(config)
+		configureDiscovery(config)
+	}
+	configureRunner(config)
+	configureHistory(config)
+	switch command {
+	case syncCmd.FullCommand():
+		os.Exit(syncCmdRun(config))
 
 	case exec.FullCommand():
 		// exec
+		start := time.Now()
+		var results []memberResult
+		resultsLock := sync.Mutex{}
+		record := func(repopath string, start time.Time, err error) {
+			resultsLock.Lock()
+			results = append(results, memberResult{repo: repopath, steps: []stepResult{{step: exec.FullCommand(), err: err, elapsed: time.Since(start)}}})
+			resultsLock.Unlock()
+		}
 		groups := dirsig.Find(*appSigFilename, "", *appDepth)
 		wg := sync.WaitGroup{}
 		if repos, ok := groups[string(*execUuid)]; ok {
 			fmt.Println("Found repository group", *execUuid, "with", len(repos), "members")
 			f := func(repopath string) {
 				fmt.Println(repopath)
-				out, _ := sh.Command("sh", "-c", "(cd "+repopath+";git "+strings.Join(*execCmd, " ")+")").CombinedOutput()
+				start := time.Now()
+				out, err := sh.Command("sh", "-c", "(cd "+repopath+";git "+strings.Join(*execCmd, " ")+")").CombinedOutput()
 				fmt.Println(string(out))
+				record(repopath, start, err)
 				wg.Done()
 			}
 			for _, repopath := range repos {
@@ -298,13 +356,19 @@ func main() {
 				}
 			}
 		}
-		sshRepos := findSshRepos(*execUuid)
+		sshRepos, discoveryErr := findSshRepos(*execUuid)
+		if discoveryErr != nil {
+			fmt.Println("error:", discoveryErr)
+			appLog.Error("", "%v", discoveryErr)
+		}
 		if len(sshRepos) > 0 {
 			f := func(repopath string) {
 				fmt.Println(repopath)
 				host, path := split_ab(repopath, ":")
-				out, _ := sh.Command("ssh", host, "sh", "-c", "\"cd "+path+";git "+strings.Join(*execCmd, " ")+"\"").CombinedOutput()
+				start := time.Now()
+				out, err := sh.Command("ssh", host, "sh", "-c", "\"cd "+path+";git "+strings.Join(*execCmd, " ")+"\"").CombinedOutput()
 				fmt.Println(string(out))
+				record(repopath, start, err)
 				wg.Done()
 			}
 			for _, repopath := range sshRepos {
@@ -317,6 +381,37 @@ func main() {
 			}
 		}
 		wg.Wait()
+		code := syncExitCode(results, discoveryErr)
+		if len(results) == 0 && code == 0 {
+			fmt.Println("error: could not find any members of\nrepository group", *execUuid)
+			code = exitGroupNotFound
+		}
+		recordHistory(newRunRecord(exec.FullCommand(), string(*execUuid), start, results, code))
+		os.Exit(code)
+
+	case policy.FullCommand():
+		policyCmdApply(config)
+
+	case trust.FullCommand():
+		trustCmdSet()
+
+	case statusCmd.FullCommand():
+		statusCmdShow()
+
+	case historyCmd.FullCommand():
+		historyCmdShow()
+
+	case installService.FullCommand():
+		installServiceCmdRun(config)
+
+	case uninstallService.FullCommand():
+		uninstallServiceCmdRun()
+
+	case daemonCmd.FullCommand():
+		daemonCmdRun()
+
+	case logCmd.FullCommand():
+		logCmdShow()
 
 	case sigNew.FullCommand():
 		dirsigCmdNew()
@@ -336,20 +431,51 @@ func split_ab(x, sep string) (a, b string) {
 	return
 }
 
+// Returns the name of the remote pointing at the group member at path. The
+// name depends only on the path, so each member keeps the same remote name
+// across runs. It ends with a hash of the path, as paths such as
+// "/media/a-b" and "/media/a/b" would otherwise get the same name.
+func remoteName(path string) string {
+	name := strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_':
+			return r
+		}
+		return '-'
+	}, path)
+	// Collapse runs of dashes left by separators
+	for strings.Contains(name, "--") {
+		name = strings.Replace(name, "--", "-", -1)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(path))
+	return fmt.Sprintf("autoannex-%s-%08x", strings.Trim(name, "-."), h.Sum32())
+}
+
+// Returns true if path is one of the local or SSH members of a group
+func isMember(path string, repos, sshRepos []string) bool {
+	for _, i := range append(append([]string{}, repos...), sshRepos...) {
+		if i == path {
+			return true
+		}
+	}
+	return false
+}
+
+// Precision to round durations to for display, by the largest duration
+// each applies to, from smallest to largest
+var precisions = []struct{ below, round time.Duration }{
+	{time.Millisecond, time.Microsecond},
+	{time.Second, time.Millisecond},
+	{time.Minute, time.Second},
+	{time.Hour, time.Second},
+}
+
 func sanePrecision(d time.Duration) time.Duration {
 	// If duration is less than ... round to nearest ...
-	order := map[time.Duration]time.Duration{
-		time.Millisecond: time.Microsecond,
-		time.Second:      time.Millisecond,
-		time.Minute:      time.Second,
-		time.Hour:        time.Second,
-	}
-	for i, j := range order {
-		if d < i {
-			if i == 0 {
-				return d
-			}
-			return ((d + j/2) / j) * j
+	for _, i := range precisions {
+		if d < i.below {
+			return ((d + i.round/2) / i.round) * i.round
 		}
 	}
 	// More than an hour, round to nearest minute
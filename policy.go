@@ -0,0 +1,131 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/hypoactiv/autoannex/dirsig"
+	"github.com/hypoactiv/autoannex/goannex"
+)
+
+// Applies the configured preferred content policy to each present member of
+// a group
+func policyCmdApply(config *Config) {
+	g := config.Group(string(*policyUuid))
+	// Command line policies override the configuration file
+	members := make(map[string]*MemberConfig)
+	for path, m := range g.Members {
+		if m != nil {
+			c := *m
+			members[path] = &c
+		}
+	}
+	for path, p := range *policyMembers {
+		if members[path] == nil {
+			members[path] = &MemberConfig{}
+		}
+		members[path].Policy = p
+	}
+	for path, m := range members {
+		if m.Policy != "" && !isStandardGroup(m.Policy) {
+			fmt.Println("error: unknown policy", m.Policy, "for", path)
+			fmt.Println("policy must be one of", goannex.StandardGroups)
+			os.Exit(exitError)
+		}
+	}
+	groups := dirsig.Find(*appSigFilename, "", *appDepth)
+	repos, ok := groups[string(*policyUuid)]
+	if !ok {
+		fmt.Println("error: could not find any members of\nrepository group", *policyUuid)
+		fmt.Println("try increasing maximum search depth")
+		os.Exit(exitGroupNotFound)
+	}
+	var first *goannex.Repo
+	for _, repopath := range repos {
+		r, err := goannex.OpenRepo(repopath)
+		if err != nil {
+			fmt.Println("error: unable to open", repopath, "\n", err)
+			continue
+		}
+		if first == nil {
+			first = r
+		}
+		if g.NumCopies > 0 {
+			if err = r.SetNumCopies(g.NumCopies); err != nil {
+				fmt.Println("error: unable to set numcopies in", repopath, "\n", err)
+			}
+		}
+		m, ok := members[repopath]
+		if !ok {
+			fmt.Println("No policy configured for", repopath)
+			continue
+		}
+		fmt.Println("Applying policy", describePolicy(m), "to", repopath)
+		if err = applyPolicy(r, goannex.Here, m); err != nil {
+			fmt.Println("error: unable to apply policy to", repopath, "\n", err)
+		}
+		delete(members, repopath)
+	}
+	// Members that aren't present locally, such as SSH members, are
+	// configured through their remote in the first local member
+	for path, m := range members {
+		if first == nil {
+			break
+		}
+		fmt.Println("Applying policy", describePolicy(m), "to", path, "via", first.Path)
+		if err := applyPolicy(first, remoteName(path), m); err != nil {
+			fmt.Println("error: unable to apply policy to", path, "(has it been synced?)\n", err)
+		}
+	}
+	fmt.Println("Run autoannex sync to share the new policies with the whole group")
+}
+
+// Sets the group, wanted and required expressions of repo according to m
+func applyPolicy(r *goannex.Repo, repo string, m *MemberConfig) (err error) {
+	if m.Policy != "" {
+		// A member belongs to at most one standard group
+		current, err := r.Groups(repo)
+		if err != nil {
+			return err
+		}
+		for _, i := range current {
+			if i != m.Policy && isStandardGroup(i) {
+				if err = r.Ungroup(repo, i); err != nil {
+					return err
+				}
+			}
+		}
+		if err = r.SetGroup(repo, m.Policy); err != nil {
+			return err
+		}
+	}
+	wanted := m.Wanted
+	if wanted == "" && m.Policy != "" {
+		wanted = "standard"
+	}
+	if wanted != "" {
+		if err = r.SetWanted(repo, wanted); err != nil {
+			return err
+		}
+	}
+	if m.Required != "" {
+		err = r.SetRequired(repo, m.Required)
+	}
+	return
+}
+
+func describePolicy(m *MemberConfig) string {
+	if m.Wanted != "" {
+		return "\"" + m.Wanted + "\""
+	}
+	return m.Policy
+}
+
+func isStandardGroup(group string) bool {
+	for _, i := range goannex.StandardGroups {
+		if i == group {
+			return true
+		}
+	}
+	return false
+}
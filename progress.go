@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hypoactiv/autoannex/goannex"
+)
+
+// Progress lines longer than this, in characters, are truncated
+const progressWidth = 79
+
+// Where progress is shown, and whether it's a terminal, on which the line
+// is redrawn as the transfer proceeds. Elsewhere, such as in a log file, the
+// \r redraws would only pile up, so just the final line is written.
+var (
+	progressOutput   io.Writer = os.Stdout
+	progressTerminal           = isTerminal(os.Stdout)
+)
+
+// Returns true if f is a terminal rather than a file or pipe
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// Drains t's progress events, rendering a single line of status for repopath
+// that is overwritten as the transfer proceeds. Returns the transfer's error.
+func showProgress(repopath string, t *goannex.Transfer) error {
+	var (
+		files, failed  int
+		done, total    int64
+		current        goannex.Progress
+		lastLineLength int
+		events         int
+	)
+	// The size of each file in flight, as not every completion event carries
+	// it
+	sizes := make(map[string]int64)
+	render := func() string {
+		line := fmt.Sprintf("%s: %d file(s), %s", filepath.Base(repopath), files, humanBytes(done))
+		if failed > 0 {
+			line += fmt.Sprintf(", %d failed", failed)
+		}
+		if current.File != "" && !current.Done {
+			line += " | " + current.File
+			if current.TotalSize > 0 {
+				line += fmt.Sprintf(" %d%%", current.BytesDone*100/current.TotalSize)
+			}
+		}
+		if r := []rune(line); len(r) > progressWidth {
+			line = string(r[:progressWidth-3]) + "..."
+		}
+		return line
+	}
+	for p := range t.Events {
+		events++
+		id := p.Key
+		if id == "" {
+			id = p.File
+		}
+		if p.Done {
+			size := p.TotalSize
+			if size == 0 {
+				size = sizes[id]
+			}
+			delete(sizes, id)
+			if p.Success {
+				files++
+				total += size
+			} else {
+				failed++
+			}
+			done = total
+		} else {
+			if p.TotalSize > 0 {
+				sizes[id] = p.TotalSize
+			}
+			done = total + p.BytesDone
+		}
+		current = p
+		if !progressTerminal {
+			continue
+		}
+		line := render()
+		length := utf8.RuneCountInString(line)
+		pad := ""
+		if length < lastLineLength {
+			pad = strings.Repeat(" ", lastLineLength-length)
+		}
+		fmt.Fprint(progressOutput, "\r", line, pad)
+		lastLineLength = length
+	}
+	if progressTerminal && lastLineLength > 0 {
+		fmt.Fprintln(progressOutput)
+	} else if !progressTerminal && events > 0 {
+		fmt.Fprintln(progressOutput, render())
+	}
+	return t.Wait()
+}
+
+// Formats n bytes in human readable binary units
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for i := n / unit; i >= unit; i /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/hypoactiv/autoannex/goannex/goannextest"
+)
+
+func TestShowProgress(t *testing.T) {
+	repos := fakeRepos(t, 1)
+	defer func(w io.Writer, t bool) { progressOutput, progressTerminal = w, t }(progressOutput, progressTerminal)
+	var out bytes.Buffer
+	progressOutput = &out
+	long := strings.Repeat("é", 100)
+	f := goannextest.NewRecorder()
+	// Two files transferred at once, whose completion events carry no size
+	f.Reply(`{"action":{"command":"get","key":"k1","file":"a"},"byte-progress":512,"total-size":1024}`+"\n"+
+		`{"action":{"command":"get","key":"k2","file":"`+long+`"},"byte-progress":1,"total-size":2048}`+"\n"+
+		`{"command":"get","key":"k1","file":"a","success":true}`+"\n"+
+		`{"command":"get","key":"k2","file":"`+long+`","success":true}`+"\n",
+		"git-annex", "get")
+	r, err := f.Runner().OpenRepo(repos[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	progressTerminal = true
+	if err = showProgress(repos[0], r.GetAutoProgress()); err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range strings.Split(strings.TrimSuffix(out.String(), "\n"), "\r")[1:] {
+		// Truncated at a character boundary
+		if !utf8.ValidString(i) || utf8.RuneCountInString(strings.TrimRight(i, " ")) > progressWidth {
+			t.Errorf("unexpected progress line %q", i)
+		}
+	}
+	if !strings.HasSuffix(out.String(), "2 file(s), 3.0 KiB\n") {
+		t.Errorf("expected the sizes of both files in the total, got %q", out.String())
+	}
+	// Elsewhere, only the final line is written
+	out.Reset()
+	progressTerminal = false
+	if err = showProgress(repos[0], r.GetAutoProgress()); err != nil {
+		t.Fatal(err)
+	}
+	if s := out.String(); strings.Contains(s, "\r") || !strings.HasSuffix(s, "2 file(s), 3.0 KiB\n") || strings.Count(s, "\n") != 1 {
+		t.Errorf("unexpected progress output %q", s)
+	}
+}
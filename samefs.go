@@ -0,0 +1,30 @@
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+// +build aix darwin dragonfly freebsd linux netbsd openbsd solaris
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// Returns true if a and b are on the same filesystem
+func sameFilesystem(a, b string) bool {
+	sa, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	sb, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	da, ok := sa.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+	db, ok := sb.Sys().(*syscall.Stat_t)
+	if !ok {
+		return false
+	}
+	return da.Dev == db.Dev
+}
@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	sh "github.com/codeskyblue/go-sh"
+)
+
+// First line of every unit written by install-service, so that
+// uninstall-service only removes units it generated
+const unitMarker = "# Generated by autoannex install-service"
+
+// Prefix of generated unit names
+const unitPrefix = "autoannex-"
+
+// How often groups are synced when neither the command line nor the group's
+// configuration says
+const defaultSchedule = "hourly"
+
+// A systemd unit file to write
+type unitFile struct {
+	name    string
+	content string
+}
+
+// What to generate units for
+type serviceOptions struct {
+	// Command line to run autoannex with, before the sync command
+	command []string
+	// Group UUIDs to sync on a schedule, and each group's schedule as a
+	// systemd calendar event
+	groups    []string
+	schedules map[string]string
+	// Mount points whose groups are synced whenever they are mounted
+	mounts []string
+	// Whether to run the daemon, which syncs groups on any mounted
+	// filesystem
+	daemon bool
+}
+
+// Returns the directory systemd looks for user units in
+func defaultUnitDir() string {
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		usr, err := user.Current()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(usr.HomeDir, ".config")
+	}
+	return filepath.Join(dir, "systemd", "user")
+}
+
+// Returns the unit files for o
+func renderUnits(o *serviceOptions) (units []unitFile) {
+	for _, g := range o.groups {
+		name := unitPrefix + "sync-" + g
+		units = append(units, unitFile{name + ".service", unitMarker + `
+[Unit]
+Description=autoannex sync of repository group ` + g + `
+
+[Service]
+Type=oneshot
+ExecStart=` + execLine(append(append([]string{}, o.command...), "sync", g)) + `
+`})
+		units = append(units, unitFile{name + ".timer", unitMarker + `
+[Unit]
+Description=Scheduled autoannex sync of repository group ` + g + `
+
+[Timer]
+OnCalendar=` + o.schedules[g] + `
+Persistent=true
+RandomizedDelaySec=5min
+
+[Install]
+WantedBy=timers.target
+`})
+	}
+	for _, m := range o.mounts {
+		mount := systemdEscapePath(m) + ".mount"
+		units = append(units, unitFile{unitPrefix + "on-" + systemdEscapePath(m) + ".service", unitMarker + `
+[Unit]
+Description=autoannex sync of repository groups on ` + m + `
+After=` + mount + `
+Requisite=` + mount + `
+
+[Service]
+Type=oneshot
+ExecStart=` + execLine(append(append([]string{}, o.command...), "sync", "--on", m)) + `
+
+[Install]
+WantedBy=` + mount + `
+`})
+	}
+	if o.daemon {
+		units = append(units, unitFile{unitPrefix + "daemon.service", unitMarker + `
+[Unit]
+Description=autoannex sync of repository groups on mounted filesystems
+
+[Service]
+ExecStart=` + execLine(append(append([]string{}, o.command...), "daemon")) + `
+Restart=on-failure
+
+[Install]
+WantedBy=default.target
+`})
+	}
+	return
+}
+
+// Returns argv as a systemd ExecStart command line
+func execLine(argv []string) string {
+	var quoted []string
+	for _, i := range argv {
+		i = strings.Replace(i, "%", "%%", -1)
+		if i == "" || strings.ContainsAny(i, " \t\"'\\;$") {
+			i = strconv.Quote(i)
+			// systemd expands $VAR even in quotes
+			i = strings.Replace(i, "$", "$$", -1)
+		}
+		quoted = append(quoted, i)
+	}
+	return strings.Join(quoted, " ")
+}
+
+// Escapes a path into a unit name, like systemd-escape --path. For example
+// "/media/user/my disk" becomes "media-user-my\x20disk".
+func systemdEscapePath(path string) string {
+	path = strings.Trim(filepath.Clean(path), "/")
+	if path == "" {
+		return "-"
+	}
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		switch {
+		case c == '/':
+			b.WriteByte('-')
+		case c == '.' && i == 0,
+			!(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == ':' || c == '_' || c == '.'):
+			fmt.Fprintf(&b, `\x%02x`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// Returns the command line that runs autoannex with the same configuration,
+// search settings and executables as this invocation, for units and the
+// daemon to run
+func selfCommand() (argv []string, err error) {
+	binary, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	config, err := filepath.Abs(*appConfig)
+	if err != nil {
+		return nil, err
+	}
+	argv = []string{binary, "--config", config}
+	if *appSigFilename != DEFAULT_SIGNATURE_FILENAME {
+		argv = append(argv, "--sig-file", *appSigFilename)
+	}
+	argv = append(argv, "--depth", strconv.FormatUint(uint64(*appDepth), 10))
+	if *appSshHosts != "" {
+		argv = append(argv, "--ssh-hosts", *appSshHosts)
+	}
+	if *appGit != "" {
+		argv = append(argv, "--git", *appGit)
+	}
+	if *appGitAnnex != "" {
+		argv = append(argv, "--git-annex", *appGitAnnex)
+	}
+	for _, i := range envList(*appEnv) {
+		argv = append(argv, "--env", i)
+	}
+	if *appLogLevel != "" {
+		argv = append(argv, "--log-level", *appLogLevel)
+	}
+	return
+}
+
+// Writes units to dir, returning their names
+func writeUnits(dir string, units []unitFile) (names []string, err error) {
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	for _, u := range units {
+		if err = ioutil.WriteFile(filepath.Join(dir, u.name), []byte(u.content), 0644); err != nil {
+			return names, err
+		}
+		names = append(names, u.name)
+	}
+	return
+}
+
+// Returns the names of the units in dir that were generated by
+// install-service
+func generatedUnits(dir string) (names []string, err error) {
+	files, err := ioutil.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		if f.IsDir() || !strings.HasPrefix(f.Name(), unitPrefix) {
+			continue
+		}
+		o, err := os.Open(filepath.Join(dir, f.Name()))
+		if err != nil {
+			return names, err
+		}
+		s := bufio.NewScanner(o)
+		if s.Scan() && s.Text() == unitMarker {
+			names = append(names, f.Name())
+		}
+		o.Close()
+	}
+	return
+}
+
+// Returns the units among names that are enabled or started, rather than
+// pulled in by another unit
+func installableUnits(names []string) (installable []string) {
+	for _, i := range names {
+		if strings.HasSuffix(i, ".timer") || strings.HasPrefix(i, unitPrefix+"on-") || i == unitPrefix+"daemon.service" {
+			installable = append(installable, i)
+		}
+	}
+	return
+}
+
+// Runs systemctl --user with args
+func systemctl(args ...string) error {
+	a := []interface{}{"--user"}
+	for _, i := range args {
+		a = append(a, i)
+	}
+	return sh.Command("systemctl", a...).Run()
+}
+
+// Generates units for the install-service command from config
+func installServiceCmdRun(config *Config) {
+	command, err := selfCommand()
+	if err != nil {
+		fmt.Println("error:", err)
+		os.Exit(exitError)
+	}
+	o := &serviceOptions{command: command, groups: *installServiceGroups, schedules: make(map[string]string), daemon: *installServiceDaemon}
+	if len(o.groups) == 0 {
+		o.groups = config.GroupNames()
+	}
+	for _, g := range o.groups {
+		o.schedules[g] = config.Group(g).Schedule
+		if *installServiceCalendar != "" {
+			o.schedules[g] = *installServiceCalendar
+		}
+		if o.schedules[g] == "" {
+			o.schedules[g] = defaultSchedule
+		}
+	}
+	for _, m := range *installServiceMounts {
+		if m, err = filepath.Abs(m); err != nil {
+			fmt.Println("error:", err)
+			os.Exit(exitError)
+		}
+		o.mounts = append(o.mounts, m)
+	}
+	if len(o.groups) == 0 && len(o.mounts) == 0 && !o.daemon {
+		fmt.Println("error: no groups are configured. Name groups with --group, mount points with --mount, or use --daemon")
+		os.Exit(exitError)
+	}
+	names, err := writeUnits(*installServiceDir, renderUnits(o))
+	for _, i := range names {
+		fmt.Println("Wrote", filepath.Join(*installServiceDir, i))
+	}
+	if err != nil {
+		fmt.Println("error: unable to write unit:", err)
+		os.Exit(exitError)
+	}
+	if !*installServiceEnable {
+		fmt.Println("To start them, run: systemctl --user daemon-reload && systemctl --user enable --now", strings.Join(installableUnits(names), " "))
+		return
+	}
+	if err = systemctl("daemon-reload"); err == nil {
+		err = systemctl(append([]string{"enable", "--now"}, installableUnits(names)...)...)
+	}
+	if err != nil {
+		fmt.Println("error: unable to enable units:", err)
+		os.Exit(exitError)
+	}
+}
+
+// Removes generated units for the uninstall-service command
+func uninstallServiceCmdRun() {
+	names, err := generatedUnits(*uninstallServiceDir)
+	if err != nil {
+		fmt.Println("error:", err)
+		os.Exit(exitError)
+	}
+	if len(names) == 0 {
+		fmt.Println("No units generated by install-service in", *uninstallServiceDir)
+		return
+	}
+	if *uninstallServiceDisable {
+		if err = systemctl(append([]string{"disable", "--now"}, installableUnits(names)...)...); err != nil {
+			fmt.Println("error: unable to disable units:", err)
+			os.Exit(exitError)
+		}
+	}
+	for _, i := range names {
+		if err = os.Remove(filepath.Join(*uninstallServiceDir, i)); err != nil {
+			fmt.Println("error:", err)
+			os.Exit(exitError)
+		}
+		fmt.Println("Removed", filepath.Join(*uninstallServiceDir, i))
+	}
+	if *uninstallServiceDisable {
+		if err = systemctl("daemon-reload"); err != nil {
+			fmt.Println("error:", err)
+			os.Exit(exitError)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSystemdEscapePath(t *testing.T) {
+	for in, out := range map[string]string{
+		"/":                      "-",
+		"/media/user/disk1/":     "media-user-disk1",
+		"/media/user/my disk":    `media-user-my\x20disk`,
+		"/mnt/usb-stick":         `mnt-usb\x2dstick`,
+		"/.snapshots/2020.01.02": `\x2esnapshots-2020.01.02`,
+	} {
+		if e := systemdEscapePath(in); e != out {
+			t.Errorf("systemdEscapePath(%q) = %q, expected %q", in, e, out)
+		}
+	}
+}
+
+func TestExecLine(t *testing.T) {
+	l := execLine([]string{"/usr/bin/autoannex", "--config", "/home/user/my config.yaml", "--env", "A=$HOME", "100%"})
+	expected := `/usr/bin/autoannex --config "/home/user/my config.yaml" --env "A=$$HOME" 100%%`
+	if l != expected {
+		t.Errorf("expected %s, got %s", expected, l)
+	}
+}
+
+func TestEnvList(t *testing.T) {
+	env := map[string]string{"PATH": "/opt/bin", "A": "1", "LANG": "C", "Z": "a=b"}
+	for i := 0; i < 20; i++ {
+		if l := strings.Join(envList(env), " "); l != "A=1 LANG=C PATH=/opt/bin Z=a=b" {
+			t.Fatal("unexpected environment", l)
+		}
+	}
+}
+
+func TestInstallService(t *testing.T) {
+	td, err := ioutil.TempDir("", "autoannex")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(td)
+	units := renderUnits(&serviceOptions{
+		command:   []string{"/usr/bin/autoannex", "--config", "/etc/autoannex.yaml"},
+		groups:    []string{"group-a", "group-b"},
+		schedules: map[string]string{"group-a": "hourly", "group-b": "daily"},
+		mounts:    []string{"/media/user/disk1"},
+	})
+	names, err := writeUnits(td, units)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(names, " ") != "autoannex-sync-group-a.service autoannex-sync-group-a.timer "+
+		"autoannex-sync-group-b.service autoannex-sync-group-b.timer autoannex-on-media-user-disk1.service" {
+		t.Error("unexpected units", names)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(td, "autoannex-sync-group-b.timer"))
+	if err != nil || !strings.Contains(string(b), "\nOnCalendar=daily\n") {
+		t.Errorf("expected a daily timer, got %s %v", b, err)
+	}
+	b, err = ioutil.ReadFile(filepath.Join(td, "autoannex-on-media-user-disk1.service"))
+	if err != nil ||
+		!strings.Contains(string(b), "\nExecStart=/usr/bin/autoannex --config /etc/autoannex.yaml sync --on /media/user/disk1\n") ||
+		!strings.Contains(string(b), "\nWantedBy=media-user-disk1.mount\n") {
+		t.Errorf("unexpected mount unit %s %v", b, err)
+	}
+	if i := installableUnits(names); strings.Join(i, " ") != "autoannex-sync-group-a.timer autoannex-sync-group-b.timer autoannex-on-media-user-disk1.service" {
+		t.Error("unexpected units to enable", i)
+	}
+	// Units not generated by install-service are left alone
+	if err = ioutil.WriteFile(filepath.Join(td, "autoannex-custom.service"), []byte("[Unit]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	generated, err := generatedUnits(td)
+	if err != nil || len(generated) != len(names) {
+		t.Error("unexpected generated units", generated, err)
+	}
+	for _, i := range generated {
+		if i == "autoannex-custom.service" {
+			t.Error("would remove a unit not generated by install-service")
+		}
+	}
+}
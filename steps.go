@@ -0,0 +1,204 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/hypoactiv/autoannex/goannex"
+)
+
+// Built in sync steps
+const (
+	stepAdd  = "add"
+	stepSync = "sync"
+	stepDrop = "drop"
+	stepGet  = "get"
+	stepCopy = "copy"
+	stepFsck = "fsck"
+	// Runs a custom git or git-annex command
+	stepCommand = "command"
+	// Syncs every member again once all other steps have run on every
+	// member. Must be the last step.
+	stepResyncAll = "resync-all"
+)
+
+// What to do when a step fails
+const (
+	// Carry on with the member's next step
+	onFailureContinue = "continue"
+	// Skip the member's remaining steps
+	onFailureAbort = "abort"
+)
+
+// One step of a sync pipeline. In the configuration file a step is either
+// just its name, eg "get", or a map with per-step options.
+type StepConfig struct {
+	Name string `yaml:"step"`
+	// Paths to add, get or copy. Defaults to the whole working tree.
+	Paths []string `yaml:"paths"`
+	// For get and drop, whether to honor preferred content. Defaults to
+	// true.
+	Auto *bool `yaml:"auto"`
+	// For copy, the member to copy content to
+	To string `yaml:"to"`
+	// Number of parallel transfers for get and copy, if non-zero
+	Jobs int `yaml:"jobs"`
+	// For command steps, the command to run, starting with git or
+	// git-annex
+	Command []string `yaml:"command"`
+	// onFailureContinue (the default) or onFailureAbort
+	OnFailure string `yaml:"on-failure"`
+}
+
+func (s *StepConfig) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	if err := unmarshal(&s.Name); err == nil {
+		return nil
+	}
+	// Avoid recursing back into this method
+	type plain StepConfig
+	if err := unmarshal((*plain)(s)); err != nil {
+		return err
+	}
+	if s.Name == "" && len(s.Command) > 0 {
+		s.Name = stepCommand
+	}
+	return nil
+}
+
+func (s *StepConfig) auto() bool {
+	return s.Auto == nil || *s.Auto
+}
+
+func (s *StepConfig) transferOptions() *goannex.TransferOptions {
+	return &goannex.TransferOptions{Paths: s.Paths, Jobs: s.Jobs}
+}
+
+// Returns the name a step's results and logs are recorded under
+func (s *StepConfig) label() string {
+	if s.Name == stepCommand && len(s.Command) > 1 {
+		return s.Command[1]
+	}
+	return s.Name
+}
+
+// Returns an error if steps is not a valid pipeline
+func validateSteps(steps []StepConfig) error {
+	for i, s := range steps {
+		switch s.Name {
+		case stepAdd, stepSync, stepDrop, stepGet, stepFsck:
+		case stepCopy:
+			if s.To == "" {
+				return errors.New("copy step requires a member to copy to")
+			}
+		case stepCommand:
+			if len(s.Command) < 2 || (s.Command[0] != "git" && s.Command[0] != "git-annex") {
+				return errors.New("command step requires a git or git-annex command")
+			}
+		case stepResyncAll:
+			if i != len(steps)-1 {
+				return errors.New("resync-all must be the last step")
+			}
+		default:
+			return errors.New("unknown step " + s.Name)
+		}
+		switch s.OnFailure {
+		case "", onFailureContinue, onFailureAbort:
+		default:
+			return errors.New("unknown on-failure policy " + s.OnFailure + " for step " + s.Name)
+		}
+	}
+	return nil
+}
+
+// Returns the pipeline selected by the sync command's flags, which matches
+// autoannex's traditional behavior
+func flagSteps(add, drop, get, fastFsck bool, copyTo string, jobs int) (steps []StepConfig) {
+	if add {
+		steps = append(steps, StepConfig{Name: stepAdd})
+	}
+	steps = append(steps, StepConfig{Name: stepSync})
+	if drop {
+		steps = append(steps, StepConfig{Name: stepDrop})
+	}
+	if get {
+		steps = append(steps, StepConfig{Name: stepGet})
+	}
+	if copyTo != "" {
+		steps = append(steps, StepConfig{Name: stepCopy, To: copyTo, Jobs: jobs})
+	}
+	if fastFsck {
+		steps = append(steps, StepConfig{Name: stepFsck})
+	}
+	if get || fastFsck || drop || copyTo != "" {
+		// Resync if things may have changed
+		steps = append(steps, StepConfig{Name: stepResyncAll})
+	}
+	return
+}
+
+// Runs a single step on the member at repopath. Returns skipped if the step
+// doesn't apply to this member.
+func runStep(r *goannex.Repo, repopath string, s *StepConfig) (skipped bool, err error) {
+	start := time.Now()
+	switch s.Name {
+	case stepAdd:
+		fmt.Println("Adding new files in", repopath, "...")
+		paths := s.Paths
+		if len(paths) == 0 {
+			paths = []string{"."}
+		}
+		for _, i := range paths {
+			if err = r.Add(i); err != nil {
+				break
+			}
+		}
+	case stepSync:
+		fmt.Println("Now syncing", repopath, "...")
+		err = r.Sync()
+	case stepDrop:
+		fmt.Println("Dropping unneeded data from", repopath, "...")
+		o := s.transferOptions()
+		o.Auto = s.auto()
+		err = r.Drop(o)
+	case stepGet:
+		fmt.Println("Copying data to", repopath, "...")
+		o := s.transferOptions()
+		o.Auto = s.auto()
+		if len(s.Paths) <= maxPathsPerCommand {
+			err = showProgress(repopath, r.GetProgress(o))
+			break
+		}
+		for i := 0; i < len(s.Paths) && err == nil; i += maxPathsPerCommand {
+			end := i + maxPathsPerCommand
+			if end > len(s.Paths) {
+				end = len(s.Paths)
+			}
+			o.Paths = s.Paths[i:end]
+			err = showProgress(repopath, r.GetProgress(o))
+		}
+	case stepCopy:
+		if s.To == repopath {
+			return true, nil
+		}
+		fmt.Println("Copying data from", repopath, "to", s.To, "...")
+		err = showProgress(repopath, r.CopyToProgress(remoteName(s.To), s.transferOptions()))
+	case stepFsck:
+		fmt.Println("Running fast fsck on", repopath, "...")
+		err = r.FastFsck()
+	case stepCommand:
+		fmt.Println("Running", strings.Join(s.Command, " "), "in", repopath, "...")
+		if s.Command[0] == "git" {
+			err = r.Git(s.Command[1:]...)
+		} else {
+			err = r.Annex(s.Command[1:]...)
+		}
+	default:
+		return true, nil
+	}
+	elapsed := time.Since(start)
+	fmt.Println("Done. Took", sanePrecision(elapsed))
+	logStep(repopath, s.label(), elapsed, err)
+	return false, err
+}
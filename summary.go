@@ -0,0 +1,130 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// Exit codes, so that cron jobs and systemd units can tell failures apart
+const (
+	// Bad configuration or usage, or git-annex is missing
+	exitError = 1
+	// At least one member failed a step
+	exitMembersFailed = 2
+	// No members of the group could be found
+	exitGroupNotFound = 3
+	// Looking for members failed, for example on an unreachable SSH host.
+	// Members that were found are still synced.
+	exitDiscoveryError = 4
+)
+
+// Outcome of one step of a member's sync
+type stepResult struct {
+	step    string
+	err     error
+	elapsed time.Duration
+	// Anything else worth reporting, such as a get limited by disk space
+	note string
+}
+
+// Outcome of syncing one member
+type memberResult struct {
+	repo  string
+	steps []stepResult
+	// Type of the filesystem the member is on, if known
+	filesystem string
+	// Whether the member was left alone as it is read-only
+	readOnly bool
+	// Whether the member's steps weren't run, because its pre-sync hook or
+	// connecting it to the group failed
+	skipped bool
+	// Variant files created by git-annex resolving merge conflicts during
+	// this sync, and files left with unresolved conflicts
+	variants  []string
+	conflicts []string
+}
+
+func (m *memberResult) hasConflicts() bool {
+	return len(m.variants) > 0 || len(m.conflicts) > 0
+}
+
+func (m *memberResult) failed() bool {
+	for _, i := range m.steps {
+		if i.err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Prints a table with one row per member of group, listing each step's
+// outcome
+func printSummary(group string, results []memberResult) {
+	fmt.Println()
+	fmt.Println("Summary of repository group", group+":")
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "MEMBER\tRESULT\tCONFLICTS\tSTEPS")
+	for _, m := range results {
+		var steps []string
+		for _, i := range m.steps {
+			status := "ok"
+			if i.err != nil {
+				status = "FAILED"
+			}
+			if i.note != "" {
+				status += " [" + i.note + "]"
+			}
+			steps = append(steps, fmt.Sprintf("%s %s (%v)", i.step, status, sanePrecision(i.elapsed)))
+		}
+		result := "ok"
+		if m.failed() {
+			result = "FAILED"
+		} else if m.readOnly {
+			result = "read-only"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", m.repo, result, len(m.conflicts)+len(m.variants), strings.Join(steps, ", "))
+	}
+	w.Flush()
+	for _, m := range results {
+		if !m.hasConflicts() {
+			continue
+		}
+		fmt.Println("\nConflicts in", m.repo+":")
+		for _, i := range m.conflicts {
+			fmt.Println("  unresolved:", i)
+		}
+		for _, i := range m.variants {
+			fmt.Println("  new variant:", i)
+		}
+	}
+}
+
+// Exit codes from most to least severe, for combining the exit codes of
+// several groups
+var exitCodeSeverity = []int{exitError, exitMembersFailed, exitGroupNotFound, exitDiscoveryError, 0}
+
+// Returns the more severe of two exit codes
+func worseExitCode(a, b int) int {
+	for _, i := range exitCodeSeverity {
+		if a == i || b == i {
+			return i
+		}
+	}
+	return a
+}
+
+// Returns the exit code for a sync with these results
+func syncExitCode(results []memberResult, discoveryErr error) int {
+	for i := range results {
+		if results[i].failed() {
+			return exitMembersFailed
+		}
+	}
+	if discoveryErr != nil {
+		return exitDiscoveryError
+	}
+	return 0
+}
@@ -0,0 +1,475 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/hypoactiv/autoannex/dirsig"
+	"github.com/hypoactiv/autoannex/goannex"
+)
+
+// Options controlling how a group is synchronized
+type syncOptions struct {
+	removeRemotes bool
+	// Steps to run on each member, in order
+	steps []StepConfig
+	// SSH hosts whose remotes are given the highest cost
+	slowHosts []string
+	// Whether to skip dropping content from members with conflicts
+	conflictsBlockDrop bool
+	// Bytes to leave free on each member's filesystem when getting content
+	diskReserve int64
+	// Mount holding each local member, keyed by path
+	mounts map[string]*dirsig.Mount
+	// Signature UUID and configuration of the group, for hooks
+	uuid  string
+	group *GroupConfig
+}
+
+// Returns the group's configuration, which is empty if none was given
+func (o *syncOptions) groupConfig() *GroupConfig {
+	if o.group == nil {
+		return &GroupConfig{}
+	}
+	return o.group
+}
+
+// Runs the sync command with the loaded config, returning the process exit
+// code
+func syncCmdRun(config *Config) int {
+	if _, err := goannex.AnnexVersion(); err != nil {
+		fmt.Println("error:", err)
+		return exitError
+	}
+	if len(*syncOn) == 0 && *syncAll == (len(*syncUuids) > 0) {
+		fmt.Println("error: give either the UUIDs of groups to synchronize, --all or --on")
+		return exitError
+	}
+	var uuids []string
+	if !*syncAll {
+		uuids = *syncUuids
+	}
+	// Members under --on trigger syncing of their groups
+	var triggers map[string][]string
+	if len(*syncOn) > 0 {
+		triggers = make(map[string][]string)
+		var on []string
+		for _, i := range *syncOn {
+			path, err := filepath.Abs(i)
+			if err != nil {
+				fmt.Println("error:", err)
+				return exitError
+			}
+			on = append(on, path)
+			for j, k := range dirsig.FindIn(path, *appSigFilename, *appDepth) {
+				triggers[j] = mergeMembers(triggers[j], k)
+			}
+		}
+		uuids = triggerGroups(triggers, uuids)
+		if len(uuids) == 0 {
+			fmt.Println("error: could not find any members of the given groups under", strings.Join(on, ", "))
+			fmt.Println("try increasing maximum search depth")
+			appLog.Error("", "no group members found under %s", strings.Join(on, ", "))
+			return exitGroupNotFound
+		}
+		fmt.Println("Found", len(uuids), "repository group(s) with members under", strings.Join(on, ", "))
+	}
+	// Members of unconfigured groups can't be found yet, so with --all only
+	// configured groups' hooks run
+	hookGroups := uuids
+	if *syncAll {
+		hookGroups = config.GroupNames()
+	}
+	for _, i := range hookGroups {
+		if config.Group(i).HasTag(*syncTags) {
+			runPreDiscoveryHooks(config.Group(i), i)
+		}
+	}
+	// One discovery pass for all groups
+	sshGroups, discoveryErr := findSshGroups(uuids)
+	if discoveryErr != nil {
+		fmt.Println("error:", discoveryErr)
+		appLog.Error("", "%v", discoveryErr)
+	}
+	groups := dirsig.Find(*appSigFilename, "", *appDepth)
+	for i, j := range triggers {
+		// The normal search may not reach as deep as the trigger's
+		groups[i] = mergeMembers(groups[i], j)
+	}
+	selected, skipped := selectGroups(uuids, *syncTags, config, groups, sshGroups)
+	for _, i := range skipped {
+		fmt.Println("Skipping repository group", i, "without two reachable members, one of them local")
+	}
+	if *syncAll && len(selected) == 0 {
+		fmt.Println("error: could not find any repository groups with at least two members")
+		fmt.Println("try increasing maximum search depth")
+		appLog.Error("", "no repository groups with at least two members found")
+		if discoveryErr != nil {
+			return exitDiscoveryError
+		}
+		return exitGroupNotFound
+	}
+	if *syncJson != "" {
+		// Each group's results are appended below
+		if err := ioutil.WriteFile(*syncJson, nil, 0644); err != nil {
+			fmt.Println("error: unable to write JSON results:", err)
+			return exitError
+		}
+	}
+	code := 0
+	for _, i := range selected {
+		code = worseExitCode(code, syncGroupCmd(config, i, groups[i], sshGroups[i], discoveryErr))
+	}
+	return code
+}
+
+// Returns the groups to synchronize: those in uuids, or if uuids is nil,
+// every group with at least two members in groups and sshGroups, which are
+// the local and SSH members found, at least one of them local. If tags are
+// given, only groups with one of the tags are synchronized. Groups left out
+// for having a single member or no local members are returned in skipped.
+func selectGroups(uuids []string, tags []string, config *Config, groups, sshGroups map[string][]string) (selected, skipped []string) {
+	seen := make(map[string]bool)
+	var candidates []string
+	if uuids != nil {
+		candidates = uuids
+	} else {
+		for _, g := range []map[string][]string{groups, sshGroups} {
+			for i := range g {
+				if !seen[i] {
+					seen[i] = true
+					candidates = append(candidates, i)
+				}
+			}
+		}
+		sort.Strings(candidates)
+		seen = make(map[string]bool)
+	}
+	for _, i := range candidates {
+		if seen[i] || !config.Group(i).HasTag(tags) {
+			continue
+		}
+		seen[i] = true
+		if uuids == nil && (len(groups[i]) == 0 || len(groups[i])+len(sshGroups[i]) < 2) {
+			skipped = append(skipped, i)
+			continue
+		}
+		selected = append(selected, i)
+	}
+	return
+}
+
+// Returns the groups, sorted, that the trigger members found under --on
+// belong to. If uuids is not nil, only groups in uuids are returned.
+func triggerGroups(triggers map[string][]string, uuids []string) (groups []string) {
+	for i := range triggers {
+		wanted := uuids == nil
+		for _, j := range uuids {
+			wanted = wanted || i == j
+		}
+		if wanted {
+			groups = append(groups, i)
+		}
+	}
+	sort.Strings(groups)
+	return
+}
+
+// Returns the members in a, plus those in b that aren't also in a
+func mergeMembers(a, b []string) []string {
+	for _, i := range b {
+		found := false
+		for _, j := range a {
+			if filepath.Clean(i) == filepath.Clean(j) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			a = append(a, i)
+		}
+	}
+	return a
+}
+
+// Synchronizes the group uuid, whose local and SSH members are repos and
+// sshRepos, returning the exit code for the group
+func syncGroupCmd(config *Config, uuid string, repos, sshRepos []string, discoveryErr error) int {
+	start := time.Now()
+	group := config.Group(uuid)
+	if len(repos) == 0 {
+		fmt.Println("error: could not find any members of\nrepository group", uuid)
+		fmt.Println("try increasing maximum search depth")
+		appLog.Error("", "no members of repository group %s found", uuid)
+		code := exitGroupNotFound
+		if discoveryErr != nil {
+			code = exitDiscoveryError
+		}
+		recordHistory(newRunRecord(syncCmd.FullCommand(), uuid, start, nil, code))
+		return code
+	}
+	fmt.Println("Found repository group", uuid, "with", len(repos), "members")
+	appLog.Info("", "syncing repository group %s with %d members", uuid, len(repos))
+	steps := flagSteps(*syncAdd, *syncDrop, *syncGet, *syncFastFsck, *syncCopyTo, *syncJobs)
+	if len(group.Steps) > 0 && !*syncAdd && !*syncDrop && !*syncGet && !*syncFastFsck && *syncCopyTo == "" {
+		// Use the group's own pipeline unless steps were given on the
+		// command line
+		steps = group.Steps
+	}
+	if err := validateSteps(steps); err != nil {
+		fmt.Println("error:", err)
+		return exitError
+	}
+	reserve := group.DiskReserve
+	if *syncReserve != "" {
+		reserve = *syncReserve
+	}
+	var diskReserve int64
+	if reserve != "" {
+		var err error
+		if diskReserve, err = parseSize(reserve); err != nil {
+			fmt.Println("error:", err)
+			return exitError
+		}
+	}
+	mounts := memberMounts(repos)
+	for _, i := range steps {
+		if i.Name == stepCopy && !isMember(i.To, repos, sshRepos) {
+			fmt.Println("error:", i.To, "is not a member of repository group", uuid)
+			return exitError
+		}
+		if i.Name == stepCopy && isReadOnly(mounts[i.To]) {
+			fmt.Println("error: can't copy to", i.To, "as it is read-only")
+			return exitError
+		}
+	}
+	results := syncGroup(goannex.DefaultRunner, repos, sshRepos, &syncOptions{
+		removeRemotes:      *syncRmRemotes,
+		steps:              steps,
+		slowHosts:          config.SlowHosts,
+		conflictsBlockDrop: *syncSafeDrop || group.ConflictsBlockDrop,
+		diskReserve:        diskReserve,
+		mounts:             mounts,
+		uuid:               uuid,
+		group:              group,
+	})
+	printSummary(uuid, results)
+	code := syncExitCode(results, discoveryErr)
+	record := newRunRecord(syncCmd.FullCommand(), uuid, start, results, code)
+	recordHistory(record)
+	if *syncJson != "" {
+		if err := appendHistory(*syncJson, record); err != nil {
+			fmt.Println("error: unable to write JSON results:", err)
+			code = worseExitCode(code, exitError)
+		}
+	}
+	appLog.Info("", "finished syncing repository group %s, exit code %d", uuid, code)
+	return code
+}
+
+// Connects each local member of a group to every other member, then runs
+// the sync steps on each local member. Returns the outcome for each member.
+func syncGroup(runner *goannex.Runner, repos, sshRepos []string, o *syncOptions) (results []memberResult) {
+	g := o.groupConfig()
+	for _, repopath := range repos {
+		m := memberResult{repo: repopath}
+		if mount := o.mounts[repopath]; mount != nil {
+			m.filesystem = mount.FSType
+		}
+		if isReadOnly(o.mounts[repopath]) {
+			fmt.Println("Not syncing", repopath, "as it is read-only. The other members get content from it")
+			appLog.Info(repopath, "read-only, only used as a source")
+			m.readOnly = true
+			results = append(results, m)
+			continue
+		}
+		err := runHooks(hookPreSync, memberHooks(g, repopath, hookPreSync), repopath, o.uuid, repopath, nil)
+		if err != nil {
+			fmt.Println("error:", err, "\nSkipping", repopath)
+			appLog.Error(repopath, "%v, skipping member", err)
+			m.steps = []stepResult{{step: hookPreSync, err: err}}
+			m.skipped = true
+		} else {
+			syncMember(runner, &m, repos, sshRepos, o)
+		}
+		if err = runHooks(hookPostSync, memberHooks(g, repopath, hookPostSync), repopath, o.uuid, repopath, m.steps); err != nil {
+			fmt.Println("error:", err)
+			appLog.Error(repopath, "%v", err)
+		}
+		if m.failed() {
+			if err = runHooks(hookOnFailure, memberHooks(g, repopath, hookOnFailure), repopath, o.uuid, repopath, m.steps); err != nil {
+				fmt.Println("error:", err)
+				appLog.Error(repopath, "%v", err)
+			}
+		}
+		results = append(results, m)
+	}
+	if n := len(o.steps); n > 0 && o.steps[n-1].Name == stepResyncAll {
+		for i, repopath := range repos {
+			if results[i].readOnly || results[i].skipped {
+				continue
+			}
+			fmt.Println("Now resyncing", repopath, "...")
+			r, err := runner.OpenRepo(repopath)
+			if err != nil {
+				logStep(repopath, stepResyncAll, 0, err)
+				results[i].steps = append(results[i].steps, stepResult{step: stepResyncAll, err: err})
+				continue
+			}
+			start := time.Now()
+			before := listVariants(r)
+			err = r.Sync()
+			elapsed := time.Since(start)
+			fmt.Println("Done. Took", sanePrecision(elapsed))
+			logStep(repopath, stepResyncAll, elapsed, err)
+			results[i].steps = append(results[i].steps, stepResult{step: stepResyncAll, err: err, elapsed: elapsed})
+			checkConflicts(r, &results[i], before)
+		}
+	}
+	return
+}
+
+// Connects the member m to the rest of its group and runs each requested
+// step on it, recording the outcome of each step and any conflicts in m
+func syncMember(runner *goannex.Runner, m *memberResult, repos, sshRepos []string, o *syncOptions) {
+	repopath := m.repo
+	r, err := runner.OpenRepo(repopath)
+	if err != nil {
+		logStep(repopath, "open", 0, err)
+		m.steps = append(m.steps, stepResult{step: "open", err: err})
+		m.skipped = true
+		return
+	}
+	if step, err := connectRemotes(r, repos, sshRepos, o); err != nil {
+		logStep(repopath, step, 0, err)
+		m.steps = append(m.steps, stepResult{step: step, err: err})
+		m.skipped = true
+		return
+	}
+	mount := o.mounts[repopath]
+	crippled := isCrippled(mount)
+	if crippled {
+		if s := checkAdjusted(r, mount, o.groupConfig().Member(repopath).Adjust); s != nil {
+			m.steps = append(m.steps, *s)
+			if s.err != nil {
+				return
+			}
+		}
+	}
+	for i := range o.steps {
+		step := &o.steps[i]
+		start := time.Now()
+		var skipped bool
+		var note string
+		var err error
+		if crippled && isUnlockStep(step) {
+			fmt.Println("Skipping", strings.Join(step.Command, " "), "in", repopath, "as files on", mount.FSType, "are always unlocked")
+			continue
+		} else if step.Name == stepGet {
+			var run *StepConfig
+			if run, note = guardGet(r, step, o.diskReserve); run == nil {
+				fmt.Println("Skipping get in", repopath)
+			} else {
+				skipped, err = runStep(r, repopath, run)
+			}
+		} else if step.Name == stepDrop && o.conflictsBlockDrop && m.hasConflicts() {
+			fmt.Println("Not dropping from", repopath, "because it has conflicts")
+			err = errConflicts
+			logStep(repopath, step.label(), 0, err)
+		} else if step.Name == stepSync {
+			before := listVariants(r)
+			skipped, err = runStep(r, repopath, step)
+			checkConflicts(r, m, before)
+		} else {
+			skipped, err = runStep(r, repopath, step)
+		}
+		if skipped {
+			continue
+		}
+		m.steps = append(m.steps, stepResult{step: step.label(), err: err, elapsed: time.Since(start), note: note})
+		if err != nil && step.OnFailure == onFailureAbort {
+			fmt.Println("Skipping remaining steps for", repopath)
+			break
+		}
+	}
+	return
+}
+
+// Removes stale autoannex remotes from r, or all remotes if requested, and
+// adds a remote for every other member of the group, costed by how close it
+// is. On failure, returns the name of the step that failed.
+func connectRemotes(r *goannex.Repo, repos, sshRepos []string, o *syncOptions) (step string, err error) {
+	// Clean up old remotes
+	remotes, err := r.ListRemotes()
+	if err != nil {
+		return "rrem", err
+	}
+	for _, remote := range remotes {
+		if o.removeRemotes || strings.HasPrefix(remote.Name, "autoannex-") {
+			if err = r.RemoveRemote(remote.Name); err != nil {
+				return "rrem", err
+			}
+		}
+	}
+	// Fully connect found repositories
+	for _, remotepath := range repos {
+		if remotepath == r.Path {
+			// Don't add a remote to ourselves
+			continue
+		}
+		if err = r.AddRemote(remoteName(remotepath), remotepath); err != nil {
+			return "rem", err
+		}
+		if err = r.SetRemoteCost(remoteName(remotepath), remoteCost(r.Path, remotepath, o.slowHosts)); err != nil {
+			return "rem", err
+		}
+		if isReadOnly(o.mounts[remotepath]) {
+			if err = r.SetRemoteReadOnly(remoteName(remotepath)); err != nil {
+				return "rem", err
+			}
+		}
+	}
+	for _, remotepath := range sshRepos {
+		if err = r.AddRemote(remoteName(remotepath), remotepath); err != nil {
+			return "extrarem", err
+		}
+		if err = r.SetRemoteCost(remoteName(remotepath), remoteCost(r.Path, remotepath, o.slowHosts)); err != nil {
+			return "extrarem", err
+		}
+	}
+	return "", nil
+}
+
+// Cost of a remote on the same filesystem as the repository using it
+const sameFilesystemCost = goannex.CheapRemoteCost / 2
+
+// Returns the git-annex cost of accessing the member at remotepath from the
+// member at repopath. Members on the same filesystem are cheapest, followed
+// by other local disks, then SSH hosts, then SSH hosts listed in slowHosts.
+func remoteCost(repopath, remotepath string, slowHosts []string) int {
+	if host, _, ok := sshMember(remotepath); ok {
+		for _, i := range slowHosts {
+			if i == host {
+				return goannex.VeryExpensiveRemoteCost
+			}
+		}
+		return goannex.ExpensiveRemoteCost
+	}
+	if sameFilesystem(repopath, remotepath) {
+		return sameFilesystemCost
+	}
+	return goannex.CheapRemoteCost
+}
+
+// Splits an SSH member's host:path location. ok is false for local members.
+func sshMember(location string) (host, path string, ok bool) {
+	if strings.HasPrefix(location, "/") || !strings.Contains(location, ":") {
+		return "", location, false
+	}
+	host, path = split_ab(location, ":")
+	return host, path, true
+}
@@ -0,0 +1,543 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/go-yaml/yaml"
+	"github.com/hypoactiv/autoannex/dirsig"
+	"github.com/hypoactiv/autoannex/goannex"
+	"github.com/hypoactiv/autoannex/goannex/goannextest"
+)
+
+// Creates n empty directories that look like git repositories to OpenRepo
+func fakeRepos(t *testing.T, n int) (repos []string) {
+	for i := 0; i < n; i++ {
+		td, err := ioutil.TempDir("", "autoannex")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = os.Mkdir(td+"/.git", 0755); err != nil {
+			t.Fatal(err)
+		}
+		repos = append(repos, td)
+		t.Cleanup(func() { os.RemoveAll(td) })
+	}
+	return
+}
+
+// Fails t unless ran contains want, in order, possibly with other commands
+// in between
+func expectCommands(t *testing.T, ran []string, want ...string) {
+	t.Helper()
+	i := 0
+	for _, j := range ran {
+		if i < len(want) && j == want[i] {
+			i++
+		}
+	}
+	if i < len(want) {
+		t.Errorf("expected command %q\nran:\n%s", want[i], strings.Join(ran, "\n"))
+	}
+}
+
+func TestSyncConnectsRemotes(t *testing.T) {
+	repos := fakeRepos(t, 2)
+	sshRepos := []string{"host:/srv/annex"}
+	f := goannextest.NewRecorder()
+	f.On(repos[0], goannextest.Result{Stdout: "origin\nautoannex-stale\n"}, "git", "remote")
+	syncGroup(f.Runner(), repos, sshRepos, &syncOptions{steps: flagSteps(false, false, false, false, "", 0)})
+	ran := f.Ran(repos[0])
+	expectCommands(t, ran,
+		"git remote",
+		"git remote rm autoannex-stale",
+		"git remote add "+remoteName(repos[1])+" "+repos[1],
+		"git config remote."+remoteName(repos[1])+".annex-cost 50",
+		"git remote add "+remoteName("host:/srv/annex")+" host:/srv/annex",
+		"git config remote."+remoteName("host:/srv/annex")+".annex-cost 200",
+		"git-annex sync",
+	)
+	for _, i := range ran {
+		if i == "git remote rm origin" {
+			t.Error("removed a remote not managed by autoannex")
+		}
+		if strings.HasPrefix(i, "git remote add "+remoteName(repos[0])) {
+			t.Error("added a remote pointing at the repository itself")
+		}
+	}
+	expectCommands(t, f.Ran(repos[1]),
+		"git remote add "+remoteName(repos[0])+" "+repos[0],
+		"git-annex sync",
+	)
+}
+
+func TestSyncSkipsMemberAfterRemoteFailure(t *testing.T) {
+	repos := fakeRepos(t, 2)
+	f := goannextest.NewRecorder()
+	f.On(repos[0], goannextest.Result{Err: os.ErrPermission}, "git", "remote", "add")
+	syncGroup(f.Runner(), repos, nil, &syncOptions{steps: flagSteps(false, false, true, false, "", 0)})
+	for _, i := range f.Ran(repos[0]) {
+		if strings.HasPrefix(i, "git-annex") {
+			t.Error("ran", i, "after failing to add remotes")
+		}
+	}
+	// The other member is unaffected
+	expectCommands(t, f.Ran(repos[1]),
+		"git-annex sync",
+		"git-annex get --json --json-progress --auto",
+		"git-annex sync",
+	)
+}
+
+func TestSyncSteps(t *testing.T) {
+	repos := fakeRepos(t, 2)
+	f := goannextest.NewRecorder()
+	f.Reply(`{"command":"get","file":"a","key":"K","success":true}`+"\n", "git-annex", "get")
+	syncGroup(f.Runner(), repos, nil, &syncOptions{steps: flagSteps(true, true, true, true, repos[1], 2)})
+	expectCommands(t, f.Ran(repos[0]),
+		"git-annex add .",
+		"git-annex sync",
+		"git-annex drop --auto",
+		"git-annex get --json --json-progress --auto",
+		"git-annex copy --json --json-progress --to "+remoteName(repos[1])+" --jobs=2",
+		"git-annex fsck --fast --quiet",
+		"git-annex sync",
+	)
+	for _, i := range f.Ran(repos[1]) {
+		if strings.HasPrefix(i, "git-annex copy") {
+			t.Error("copied the --copy-to member to itself")
+		}
+	}
+}
+
+func TestSyncConfiguredSteps(t *testing.T) {
+	repos := fakeRepos(t, 2)
+	var config Config
+	err := yaml.Unmarshal([]byte(`
+groups:
+  g:
+    steps:
+      - add
+      - sync
+      - step: get
+        jobs: 4
+        on-failure: abort
+      - command: [git-annex, unused]
+      - sync
+      - step: drop
+        auto: false
+        paths: [old]
+`), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	steps := config.Group("g").Steps
+	if err = validateSteps(steps); err != nil {
+		t.Fatal(err)
+	}
+	f := goannextest.NewRecorder()
+	f.On(repos[1], goannextest.Result{Err: os.ErrPermission}, "git-annex", "get")
+	syncGroup(f.Runner(), repos, nil, &syncOptions{steps: steps})
+	expectCommands(t, f.Ran(repos[0]),
+		"git-annex add .",
+		"git-annex sync",
+		"git-annex get --json --json-progress --auto --jobs=4",
+		"git-annex unused",
+		"git-annex sync",
+		"git-annex drop old",
+	)
+	// A failed get aborts the rest of the member's pipeline
+	ran := f.Ran(repos[1])
+	if last := ran[len(ran)-1]; !strings.HasPrefix(last, "git-annex get") {
+		t.Error("expected get to be the last command after it failed, ran", last)
+	}
+}
+
+func TestSyncExitCode(t *testing.T) {
+	repos := fakeRepos(t, 2)
+	f := goannextest.NewRecorder()
+	results := syncGroup(f.Runner(), repos, nil, &syncOptions{steps: flagSteps(false, true, false, false, "", 0)})
+	if len(results) != 2 || len(results[0].steps) != 3 {
+		t.Fatal("expected sync, drop and resync results for 2 members, got", results)
+	}
+	if c := syncExitCode(results, nil); c != 0 {
+		t.Error("expected exit code 0, got", c)
+	}
+	if c := syncExitCode(results, errors.New("host down")); c != exitDiscoveryError {
+		t.Error("expected discovery error exit code, got", c)
+	}
+	f.On(repos[1], goannextest.Result{Err: os.ErrPermission}, "git-annex", "drop")
+	results = syncGroup(f.Runner(), repos, nil, &syncOptions{steps: flagSteps(false, true, false, false, "", 0)})
+	if results[0].failed() || !results[1].failed() {
+		t.Error("expected only the second member to fail", results)
+	}
+	if c := syncExitCode(results, errors.New("host down")); c != exitMembersFailed {
+		t.Error("expected members failed exit code, got", c)
+	}
+	printSummary("group-uuid", results)
+}
+
+func TestSyncConflicts(t *testing.T) {
+	repos := fakeRepos(t, 2)
+	f := goannextest.NewRecorder()
+	// Syncing the first member creates a variant file
+	synced := false
+	f.Handle(repos[0], func(c *goannex.Command) goannextest.Result {
+		synced = true
+		return goannextest.Result{}
+	}, "git-annex", "sync")
+	f.Handle(repos[0], func(c *goannex.Command) goannextest.Result {
+		if synced {
+			return goannextest.Result{Stdout: "old.variant-1111\x00new.variant-a1b2\x00"}
+		}
+		return goannextest.Result{Stdout: "old.variant-1111\x00"}
+	}, "git", "ls-files")
+	f.On(repos[0], goannextest.Result{Stdout: "both.txt\x00"}, "git", "diff", "--name-only")
+	results := syncGroup(f.Runner(), repos, nil, &syncOptions{
+		steps:              flagSteps(false, true, false, false, "", 0),
+		conflictsBlockDrop: true,
+	})
+	m := results[0]
+	if len(m.variants) != 1 || m.variants[0] != "new.variant-a1b2" || len(m.conflicts) != 1 || m.conflicts[0] != "both.txt" {
+		t.Errorf("unexpected conflicts %v and variants %v", m.conflicts, m.variants)
+	}
+	for _, i := range f.Ran(repos[0]) {
+		if strings.HasPrefix(i, "git-annex drop") {
+			t.Error("dropped content from a member with conflicts")
+		}
+	}
+	if m.steps[1].step != stepDrop || m.steps[1].err != errConflicts {
+		t.Error("expected the drop to be refused, got", m.steps)
+	}
+	if results[1].hasConflicts() {
+		t.Error("unexpected conflicts in", repos[1])
+	}
+	expectCommands(t, f.Ran(repos[1]), "git-annex sync", "git-annex drop --auto")
+	printSummary("group-uuid", results)
+}
+
+func TestValidateSteps(t *testing.T) {
+	for _, steps := range [][]StepConfig{
+		{{Name: "bogus"}},
+		{{Name: stepCopy}},
+		{{Name: stepCommand, Command: []string{"rm", "-rf", "/"}}},
+		{{Name: stepResyncAll}, {Name: stepSync}},
+		{{Name: stepSync, OnFailure: "retry"}},
+	} {
+		if validateSteps(steps) == nil {
+			t.Error("expected invalid pipeline", steps)
+		}
+	}
+}
+
+func TestSyncSlowHostCost(t *testing.T) {
+	repos := fakeRepos(t, 1)
+	f := goannextest.NewRecorder()
+	syncGroup(f.Runner(), repos, []string{"far:/annex", "near:/annex"}, &syncOptions{slowHosts: []string{"far"}})
+	expectCommands(t, f.Ran(repos[0]),
+		"git config remote."+remoteName("far:/annex")+".annex-cost 1000",
+		"git config remote."+remoteName("near:/annex")+".annex-cost 200",
+	)
+}
+
+func TestSyncHooks(t *testing.T) {
+	repos := fakeRepos(t, 2)
+	out := repos[0] + "/hooks.out"
+	// A hook discovered in the repository
+	if err := os.MkdirAll(repos[0]+"/"+hookDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	script := "#!/bin/sh\necho \"$AUTOANNEX_EVENT $AUTOANNEX_GROUP $AUTOANNEX_REPO $AUTOANNEX_RESULT $AUTOANNEX_STEPS\" >> " + out + "\n"
+	if err := ioutil.WriteFile(repos[0]+"/"+hookDir+"/post-sync", []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	f := goannextest.NewRecorder()
+	f.On(repos[0], goannextest.Result{Err: os.ErrPermission}, "git-annex", "sync")
+	syncGroup(f.Runner(), repos, nil, &syncOptions{
+		steps: append(flagSteps(false, false, false, false, "", 0), StepConfig{Name: stepResyncAll}),
+		uuid:  "group-uuid",
+		group: &GroupConfig{
+			// A configured group hook, run in each member's directory
+			Hooks: map[string][]string{hookPreSync: {"echo pre-sync >> hooks.out"}},
+			Members: map[string]*MemberConfig{
+				repos[0]: {
+					Hooks:     map[string][]string{hookOnFailure: {"echo \"failed $AUTOANNEX_FAILED_STEPS\" >> hooks.out"}},
+					RepoHooks: true,
+				},
+				// A failing pre-sync hook skips the member
+				repos[1]: {Hooks: map[string][]string{hookPreSync: {"false"}}},
+			},
+		},
+	})
+	b, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "pre-sync\n" +
+		"post-sync group-uuid " + repos[0] + " failed sync=failed\n" +
+		"failed sync\n"
+	if string(b) != expected {
+		t.Errorf("expected hook output:\n%s\ngot:\n%s", expected, b)
+	}
+	if len(f.Ran(repos[1])) != 0 {
+		t.Error("synced a member whose pre-sync hook failed")
+	}
+	// Hooks in the repository only run when enabled
+	if h := memberHooks(&GroupConfig{}, repos[0], hookPostSync); len(h) != 0 {
+		t.Error("expected no hooks without repo-hooks, got", h)
+	}
+	// A relative executable runs from the member's directory, not ours
+	if err := ioutil.WriteFile(repos[1]+"/hook.sh", []byte("#!/bin/sh\necho relative >> "+out+"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := runHooks(hookPostSync, []string{"hook.sh"}, repos[1], "", repos[1], nil); err != nil {
+		t.Error(err)
+	}
+	if b, _ = ioutil.ReadFile(out); !strings.HasSuffix(string(b), "relative\n") {
+		t.Errorf("expected the relative hook to run, got:\n%s", b)
+	}
+}
+
+func TestRemoteName(t *testing.T) {
+	for in, out := range map[string]string{
+		"/media/user/disk1":      "autoannex-media-user-disk1",
+		"/home/user/my annex/":   "autoannex-home-user-my-annex",
+		"hostA:/srv/annex":       "autoannex-hostA-srv-annex",
+		"host.lan:~/annex.2/sub": "autoannex-host.lan-annex.2-sub",
+	} {
+		// Followed by a hash of the path
+		if name := remoteName(in); !strings.HasPrefix(name, out+"-") || len(name) != len(out)+9 {
+			t.Errorf("remoteName(%q) = %q, expected %q and a hash", in, name, out)
+		}
+	}
+	for _, i := range [][2]string{
+		{"/media/a-b", "/media/a/b"},
+		{"/x/my disk", "/x/my-disk"},
+		{"hostA:/srv", "/hostA/srv"},
+	} {
+		if remoteName(i[0]) == remoteName(i[1]) {
+			t.Errorf("%q and %q have the same remote name %q", i[0], i[1], remoteName(i[0]))
+		}
+	}
+	if remoteName("/media/user/disk1") != remoteName("/media/user/disk1") {
+		t.Error("expected remote names to be stable")
+	}
+}
+
+func TestSelectGroups(t *testing.T) {
+	var config Config
+	err := yaml.Unmarshal([]byte(`
+groups:
+  a:
+    tags: [laptop]
+  c:
+    tags: [nas, laptop]
+  d:
+    tags: [nas]
+`), &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	groups := map[string][]string{
+		"a": {"/media/disk1/a", "/home/user/a"},
+		"b": {"/media/disk1/b"},
+		"c": {"/media/disk1/c"},
+		"d": {"/media/disk1/d", "/home/user/d"},
+	}
+	sshGroups := map[string][]string{"c": {"nas:/srv/c"}, "e": {"nas:/srv/e", "backup:/srv/e"}}
+	for _, i := range []struct {
+		uuids    []string
+		tags     []string
+		selected []string
+		skipped  []string
+	}{
+		// Every group with at least two members, including over SSH, but
+		// at least one local member to sync
+		{nil, nil, []string{"a", "c", "d"}, []string{"b", "e"}},
+		{nil, []string{"laptop"}, []string{"a", "c"}, nil},
+		// Named groups are synced even with a single member
+		{[]string{"b", "a", "b"}, nil, []string{"b", "a"}, nil},
+		{[]string{"b", "d"}, []string{"nas"}, []string{"d"}, nil},
+	} {
+		selected, skipped := selectGroups(i.uuids, i.tags, &config, groups, sshGroups)
+		if strings.Join(selected, " ") != strings.Join(i.selected, " ") || strings.Join(skipped, " ") != strings.Join(i.skipped, " ") {
+			t.Errorf("selectGroups(%v, %v) = %v, %v, expected %v, %v", i.uuids, i.tags, selected, skipped, i.selected, i.skipped)
+		}
+	}
+}
+
+func TestWorseExitCode(t *testing.T) {
+	if c := worseExitCode(exitDiscoveryError, exitMembersFailed); c != exitMembersFailed {
+		t.Error("expected members failed to win, got", c)
+	}
+	if c := worseExitCode(0, exitGroupNotFound); c != exitGroupNotFound {
+		t.Error("expected group not found, got", c)
+	}
+	if c := worseExitCode(0, 0); c != 0 {
+		t.Error("expected success, got", c)
+	}
+}
+
+func TestTriggerGroups(t *testing.T) {
+	triggers := map[string][]string{
+		"b": {"/media/disk1/b"},
+		"a": {"/media/disk1/a", "/media/disk1/nested/a"},
+	}
+	if g := triggerGroups(triggers, nil); strings.Join(g, " ") != "a b" {
+		t.Error("expected groups a and b, got", g)
+	}
+	if g := triggerGroups(triggers, []string{"b", "c"}); strings.Join(g, " ") != "b" {
+		t.Error("expected only group b, got", g)
+	}
+	members := mergeMembers([]string{"/home/user/a", "/media/disk1/a/"}, triggers["a"])
+	if strings.Join(members, " ") != "/home/user/a /media/disk1/a/ /media/disk1/nested/a" {
+		t.Error("unexpected merged members", members)
+	}
+}
+
+func TestSyncDiskSpace(t *testing.T) {
+	repos := fakeRepos(t, 2)
+	free := map[string]int64{repos[0]: 1000, repos[1]: 100}
+	defer func(f func(string) (int64, error)) { freeSpace = f }(freeSpace)
+	freeSpace = func(path string) (int64, error) { return free[path], nil }
+	f := goannextest.NewRecorder()
+	f.Reply(`{"file":"a","bytesize":"400"}`+"\n"+
+		`{"file":"b","bytesize":"400"}`+"\n"+
+		`{"file":"c","bytesize":"200"}`+"\n",
+		"git-annex", "find")
+	results := syncGroup(f.Runner(), repos, nil, &syncOptions{
+		steps:       flagSteps(false, false, true, false, "", 0),
+		diskReserve: 300,
+	})
+	// Only a and c fit in the first member, leaving 300 bytes free
+	expectCommands(t, f.Ran(repos[0]),
+		"git-annex find --json --not --in=here --want-get",
+		"git-annex get --json --json-progress a c",
+	)
+	// Nothing fits in the second member
+	for _, i := range f.Ran(repos[1]) {
+		if strings.HasPrefix(i, "git-annex get") {
+			t.Error("got content into a full member:", i)
+		}
+	}
+	for i, m := range results {
+		if m.steps[1].step != stepGet || m.steps[1].note == "" || m.steps[1].err != nil {
+			t.Errorf("expected member %d's get to be limited, got %+v", i, m.steps[1])
+		}
+	}
+	// With enough space, get honors preferred content as usual
+	free[repos[1]] = 10000
+	syncGroup(f.Runner(), repos[1:], nil, &syncOptions{steps: flagSteps(false, false, true, false, "", 0)})
+	expectCommands(t, f.Ran(repos[1]), "git-annex get --json --json-progress --auto")
+	// Files of unknown size make the check approximate, and are left out
+	// when space is short
+	f = goannextest.NewRecorder()
+	f.Reply(`{"file":"a","bytesize":"400"}`+"\n"+
+		`{"file":"web","bytesize":"unknown"}`+"\n"+
+		`{"file":"b","bytesize":"400"}`+"\n",
+		"git-annex", "find")
+	results = syncGroup(f.Runner(), repos[1:], nil, &syncOptions{steps: flagSteps(false, false, true, false, "", 0)})
+	if note := results[0].steps[1].note; !strings.Contains(note, "approximate") {
+		t.Error("expected the check to be noted as approximate, got", note)
+	}
+	free[repos[0]] = 500
+	results = syncGroup(f.Runner(), repos[:1], nil, &syncOptions{steps: flagSteps(false, false, true, false, "", 0), diskReserve: 50})
+	expectCommands(t, f.Ran(repos[0]), "git-annex get --json --json-progress a")
+	if note := results[0].steps[1].note; !strings.Contains(note, "1 file(s) of unknown size") {
+		t.Error("expected the file of unknown size to be noted, got", note)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	for in, out := range map[string]int64{
+		"100":   100,
+		"2k":    2048,
+		"1.5M":  3 << 19,
+		"10GiB": 10 << 30,
+		"1 TB":  1 << 40,
+		"512 b": 512,
+	} {
+		if n, err := parseSize(in); err != nil || n != out {
+			t.Errorf("parseSize(%q) = %d, %v, expected %d", in, n, err, out)
+		}
+	}
+	for _, i := range []string{"", "ten", "-1G", "5i", "3X"} {
+		if _, err := parseSize(i); err == nil {
+			t.Errorf("expected parseSize(%q) to fail", i)
+		}
+	}
+}
+
+func TestSyncCrippledFilesystem(t *testing.T) {
+	repos := fakeRepos(t, 3)
+	f := goannextest.NewRecorder()
+	f.Reply("master\n", "git", "symbolic-ref")
+	f.On(repos[2], goannextest.Result{Stdout: "adjusted/master(unlocked)\n"}, "git", "symbolic-ref")
+	steps := []StepConfig{
+		{Name: stepCommand, Command: []string{"git-annex", "unlock", "."}},
+		{Name: stepCommand, Command: []string{"git", "annex", "unlock", "."}},
+		{Name: stepSync},
+	}
+	results := syncGroup(f.Runner(), repos, nil, &syncOptions{
+		steps: steps,
+		mounts: map[string]*dirsig.Mount{
+			repos[0]: {FSType: "ext4", Mountpoint: "/"},
+			repos[1]: {FSType: "exfat", Mountpoint: "/media/card"},
+			repos[2]: {FSType: "vfat", Mountpoint: "/media/stick"},
+		},
+		group: &GroupConfig{Members: map[string]*MemberConfig{repos[1]: {Adjust: true}}},
+	})
+	expectCommands(t, f.Ran(repos[0]), "git-annex unlock .", "git annex unlock .", "git-annex sync")
+	// Switched to an adjusted branch, and not unlocked
+	expectCommands(t, f.Ran(repos[1]), "git symbolic-ref --short HEAD", "git-annex adjust --unlock", "git-annex sync")
+	for _, i := range append(f.Ran(repos[1]), f.Ran(repos[2])...) {
+		if strings.HasPrefix(i, "git-annex unlock") || strings.HasPrefix(i, "git annex unlock") {
+			t.Error("unlocked files on a filesystem without symlinks:", i)
+		}
+	}
+	// Already adjusted
+	for _, i := range f.Ran(repos[2]) {
+		if strings.HasPrefix(i, "git-annex adjust") {
+			t.Error("adjusted a member with an adjusted branch:", i)
+		}
+	}
+	if results[1].filesystem != "exfat" || results[1].steps[0].step != "adjust" || len(results[1].steps) != 2 {
+		t.Errorf("unexpected result %+v", results[1])
+	}
+	if len(results[2].steps) != 1 {
+		t.Errorf("unexpected result %+v", results[2])
+	}
+}
+
+func TestSyncReadOnly(t *testing.T) {
+	repos := fakeRepos(t, 2)
+	f := goannextest.NewRecorder()
+	results := syncGroup(f.Runner(), repos, nil, &syncOptions{
+		steps: flagSteps(true, true, true, false, "", 0),
+		mounts: map[string]*dirsig.Mount{
+			repos[0]: {FSType: "ext4", Mountpoint: "/", Options: []string{"rw"}, SuperOptions: []string{"rw"}},
+			repos[1]: {FSType: "iso9660", Mountpoint: "/media/cdrom", Options: []string{"ro"}, SuperOptions: []string{"ro"}},
+		},
+	})
+	// The read-only member is a source for the writable one
+	name := remoteName(repos[1])
+	expectCommands(t, f.Ran(repos[0]),
+		"git remote add "+name+" "+repos[1],
+		"git config remote."+name+".annex-readonly true",
+		"git-annex add .",
+		"git-annex sync",
+		"git-annex get --json --json-progress --auto",
+	)
+	if ran := f.Ran(repos[1]); len(ran) != 0 {
+		t.Error("ran commands in a read-only member:\n" + strings.Join(ran, "\n"))
+	}
+	if !results[1].readOnly || results[1].failed() || len(results[1].steps) != 0 {
+		t.Errorf("unexpected result %+v", results[1])
+	}
+	if code := syncExitCode(results, nil); code != 0 {
+		t.Error("expected success, got exit code", code)
+	}
+}
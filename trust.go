@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/hypoactiv/autoannex/dirsig"
+	"github.com/hypoactiv/autoannex/goannex"
+	uuid "github.com/nu7hatch/gouuid"
+)
+
+// Sets the trust level of a member in every present member of its group, so
+// that they all agree on it
+func trustCmdSet() {
+	level := goannex.TrustLevel(*trustLevel)
+	groups := dirsig.Find(*appSigFilename, "", *appDepth)
+	repos, ok := groups[string(*trustUuid)]
+	if !ok {
+		fmt.Println("error: could not find any members of\nrepository group", *trustUuid)
+		fmt.Println("try increasing maximum search depth")
+		return
+	}
+	target := trustTarget(*trustMember, repos)
+	fmt.Println("Marking", *trustMember, "as", level)
+	for _, repopath := range repos {
+		r, err := goannex.OpenRepo(repopath)
+		if err != nil {
+			fmt.Println("error: unable to open", repopath, "\n", err)
+			continue
+		}
+		if err = r.SetTrust(target, level); err != nil {
+			fmt.Println("error: unable to set trust level in", repopath, "\n", err)
+			continue
+		}
+		fmt.Println("Updated", repopath)
+	}
+	fmt.Println("Run autoannex sync to share the new trust level with the whole group")
+}
+
+// Returns how git-annex should refer to member. Present members are referred
+// to by their annex UUID, so the result is the same in every repository.
+// Absent members, such as a dead drive, may be given by annex UUID or by the
+// path they were synced from, which names their remote.
+func trustTarget(member string, repos []string) string {
+	if _, err := uuid.ParseHex(member); err == nil {
+		return member
+	}
+	for _, repopath := range repos {
+		if repopath != member {
+			continue
+		}
+		r, err := goannex.OpenRepo(repopath)
+		if err != nil {
+			break
+		}
+		i, err := r.Info()
+		if err != nil || i.Here() == nil {
+			break
+		}
+		return i.Here().UUID
+	}
+	return remoteName(member)
+}